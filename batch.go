@@ -0,0 +1,110 @@
+package rindb
+
+import "sort"
+
+// WriteBatch groups writes which are applied atomically: they are logged
+// as one WAL entry and get consecutive sequence numbers.
+type WriteBatch struct {
+	entries []batchEntry
+}
+
+// batchEntry is a put, a delete or a range deletion of [record.Key, end)
+type batchEntry struct {
+	record RecordImpl
+	ranged bool
+	end    Bytes
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put stores value for key
+func (b *WriteBatch) Put(key, value Bytes) {
+	b.entries = append(b.entries, batchEntry{record: RecordImpl{Key: key, Value: value}})
+}
+
+// Delete deletes key
+func (b *WriteBatch) Delete(key Bytes) {
+	b.entries = append(b.entries, batchEntry{record: RecordImpl{Key: key, Type: RecordTypeDelete}})
+}
+
+// DeleteRange deletes the keys from start included to end excluded
+func (b *WriteBatch) DeleteRange(start, end Bytes) {
+	b.entries = append(b.entries, batchEntry{
+		record: RecordImpl{Key: start, Type: RecordTypeDelete},
+		ranged: true,
+		end:    end,
+	})
+}
+
+// Len returns the number of writes of the batch
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// Clear empties the batch so it can be reused
+func (b *WriteBatch) Clear() {
+	b.entries = b.entries[:0]
+}
+
+// hasRangeDeletions reports whether the batch holds a range deletion,
+// its records then depend on the keys stored before it
+func (b *WriteBatch) hasRangeDeletions() bool {
+	for _, entry := range b.entries {
+		if entry.ranged {
+			return true
+		}
+	}
+	return false
+}
+
+// inRange reports whether start <= key < end
+func inRange(key, start, end Bytes) bool {
+	return Compare(key, start) != CmpLess && Compare(key, end) == CmpLess
+}
+
+// records turns the batch into records, range deletions are expanded into
+// a tombstone for every live key of the range. liveKeys returns the keys
+// of the range which are stored before the batch.
+func (b *WriteBatch) records(liveKeys func(start, end Bytes) ([]Bytes, error)) ([]RecordImpl, error) {
+	records := make([]RecordImpl, 0, len(b.entries))
+	for _, entry := range b.entries {
+		if !entry.ranged {
+			records = append(records, entry.record)
+			continue
+		}
+
+		start, end := entry.record.Key, entry.end
+		keys, err := liveKeys(start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		// the batch is applied as a whole, so its own writes are
+		// live keys too
+		latest := make(map[string]RecordType, len(records))
+		for _, record := range records {
+			if inRange(record.Key, start, end) {
+				latest[string(record.Key)] = record.Type
+			}
+		}
+		for _, key := range keys {
+			if _, ok := latest[string(key)]; !ok {
+				latest[string(key)] = RecordTypePut
+			}
+		}
+
+		live := make([]string, 0, len(latest))
+		for key, recordType := range latest {
+			if recordType == RecordTypePut {
+				live = append(live, key)
+			}
+		}
+		sort.Strings(live)
+		for _, key := range live {
+			records = append(records, RecordImpl{Key: Bytes(key), Type: RecordTypeDelete})
+		}
+	}
+	return records, nil
+}
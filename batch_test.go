@@ -0,0 +1,92 @@
+package rindb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//nolint:funlen
+func TestWriteBatch(t *testing.T) {
+	t.Run("range deletions expand into tombstones of live keys", func(t *testing.T) {
+		batch := NewWriteBatch()
+		batch.Put(Bytes("b"), Bytes("b"))
+		batch.Put(Bytes("x"), Bytes("x"))
+		batch.Delete(Bytes("c"))
+		batch.DeleteRange(Bytes("a"), Bytes("d"))
+		batch.Put(Bytes("c"), Bytes("c"))
+		assert.Equal(t, 5, batch.Len())
+
+		liveKeys := func(start, end Bytes) ([]Bytes, error) {
+			return []Bytes{Bytes("a"), Bytes("c")}, nil
+		}
+		records, err := batch.records(liveKeys)
+		assert.NoError(t, err)
+
+		got := make([]string, 0, len(records))
+		for _, record := range records {
+			got = append(got, fmt.Sprintf("%d:%s", record.Type, record.Key))
+		}
+		assert.Equal(t, []string{"0:b", "0:x", "1:c", "1:a", "1:b", "0:c"}, got)
+
+		batch.Clear()
+		assert.Zero(t, batch.Len())
+	})
+
+	t.Run("batch gets one sequence range", func(t *testing.T) {
+		db, err := Open(t.TempDir(), &Options{NoSync: true})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		assert.NoError(t, db.Put(Bytes("a"), Bytes("a")))
+
+		batch := NewWriteBatch()
+		batch.Put(Bytes("b"), Bytes("b"))
+		batch.Put(Bytes("c"), Bytes("c"))
+		batch.Delete(Bytes("a"))
+		assert.NoError(t, db.Write(batch))
+		assert.Equal(t, uint64(4), db.rin.lastSeq)
+
+		record, err := db.rin.lookup(Bytes("c"), MaxSequence, true)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), record.GetSeq())
+
+		_, err = db.Get(Bytes("a"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+
+		assert.NoError(t, db.Write(NewWriteBatch()))
+		assert.Equal(t, uint64(4), db.rin.lastSeq)
+	})
+
+	t.Run("range deletion reaches every level", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{MemtableSize: 64, NoSync: true, DisableAutoCompaction: true}
+		db, err := Open(dir, opts)
+		assert.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, db.Put(Bytes(fmt.Sprintf("key%02d", i)), Bytes("value")))
+		}
+		db.rin.flushes.Wait()
+		assert.Greater(t, db.hino.levels[0].Len(), 0)
+
+		batch := NewWriteBatch()
+		batch.DeleteRange(Bytes("key05"), Bytes("key15"))
+		assert.NoError(t, db.Write(batch))
+		assert.NoError(t, db.Close())
+
+		db, err = Open(dir, opts)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		for i := 0; i < 20; i++ {
+			_, err := db.Get(Bytes(fmt.Sprintf("key%02d", i)))
+			if i >= 5 && i < 15 {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+			} else {
+				assert.NoError(t, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package rindb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// A block holds entries sorted by key followed by the restart points and
+// their count, both as 4 bytes numbers:
+//
+//	[shared][unshared][value len][key delta][value] ... [restarts] [count]
+//
+// Every entry only stores the part of its key which differs from the
+// previous key, its first shared bytes are taken from it. Entries at a
+// restart point store their whole key, so they can be read alone and
+// searched by binary search.
+
+const restartSize = 4
+
+var ErrMalformedBlock = errors.New("malformed block")
+
+type blockBuilder struct {
+	buf             bytes.Buffer
+	restarts        []uint32
+	restartInterval int
+
+	// counter is the number of entries since the last restart point
+	counter int
+	lastKey []byte
+}
+
+func newBlockBuilder(restartInterval int) *blockBuilder {
+	return &blockBuilder{restartInterval: restartInterval, restarts: []uint32{0}}
+}
+
+// add appends an entry, keys must be added in order
+func (b *blockBuilder) add(key, value []byte) {
+	shared := 0
+	if b.counter < b.restartInterval {
+		for shared < len(key) && shared < len(b.lastKey) && key[shared] == b.lastKey[shared] {
+			shared++
+		}
+	} else {
+		b.restarts = append(b.restarts, uint32(b.buf.Len()))
+		b.counter = 0
+	}
+
+	var varint [binary.MaxVarintLen64]byte
+	for _, number := range []int{shared, len(key) - shared, len(value)} {
+		b.buf.Write(varint[:binary.PutUvarint(varint[:], uint64(number))])
+	}
+	b.buf.Write(key[shared:])
+	b.buf.Write(value)
+
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.counter++
+}
+
+func (b *blockBuilder) empty() bool {
+	return b.buf.Len() == 0
+}
+
+// estimatedSize is the size of the block once finished
+func (b *blockBuilder) estimatedSize() int {
+	return b.buf.Len() + (len(b.restarts)+1)*restartSize
+}
+
+// finish appends the restart points and returns the block, the builder
+// is reset afterwards
+func (b *blockBuilder) finish() []byte {
+	var number [restartSize]byte
+	for _, restart := range b.restarts {
+		binary.LittleEndian.PutUint32(number[:], restart)
+		b.buf.Write(number[:])
+	}
+	binary.LittleEndian.PutUint32(number[:], uint32(len(b.restarts)))
+	b.buf.Write(number[:])
+
+	block := append([]byte(nil), b.buf.Bytes()...)
+	b.buf.Reset()
+	b.restarts = b.restarts[:1]
+	b.counter = 0
+	b.lastKey = b.lastKey[:0]
+	return block
+}
+
+// block is a decoded block, entries is the region of the entries
+type block struct {
+	entries  []byte
+	restarts []uint32
+}
+
+func decodeBlock(data []byte) (*block, error) {
+	if len(data) < restartSize {
+		return nil, ErrMalformedBlock
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[len(data)-restartSize:]))
+	restartsOffset := len(data) - (count+1)*restartSize
+	if count == 0 || restartsOffset < 0 {
+		return nil, ErrMalformedBlock
+	}
+
+	restarts := make([]uint32, count)
+	for idx := range restarts {
+		restarts[idx] = binary.LittleEndian.Uint32(data[restartsOffset+idx*restartSize:])
+		if int(restarts[idx]) > restartsOffset {
+			return nil, ErrMalformedBlock
+		}
+	}
+	return &block{entries: data[:restartsOffset], restarts: restarts}, nil
+}
+
+// blockIterator walks the entries of a block, keys are ordered by cmp
+type blockIterator struct {
+	block *block
+	cmp   func(a, b []byte) int
+
+	// offset is the offset of the current entry, next the one of the
+	// following entry. offset is past the entries when not valid.
+	offset, next int
+	key, value   []byte
+	err          error
+}
+
+func (b *block) newIterator(cmp func(a, b []byte) int) *blockIterator {
+	return &blockIterator{block: b, cmp: cmp, offset: len(b.entries), next: len(b.entries)}
+}
+
+func (it *blockIterator) Valid() bool {
+	return it.err == nil && it.offset < len(it.block.entries)
+}
+
+// parseNext reads the entry at next, it reports whether there was one
+func (it *blockIterator) parseNext() bool {
+	entries := it.block.entries
+	it.offset = it.next
+	if it.offset >= len(entries) {
+		it.offset, it.next = len(entries), len(entries)
+		return false
+	}
+
+	pos := it.offset
+	var numbers [3]uint64
+	for idx := range numbers {
+		number, size := binary.Uvarint(entries[pos:])
+		if size <= 0 {
+			return it.corrupted()
+		}
+		numbers[idx] = number
+		pos += size
+	}
+	shared, unshared, valueLen := int(numbers[0]), int(numbers[1]), int(numbers[2])
+	if shared > len(it.key) || pos+unshared+valueLen > len(entries) {
+		return it.corrupted()
+	}
+
+	// a new slice is given to every key, callers may keep them
+	key := make([]byte, shared+unshared)
+	copy(key, it.key[:shared])
+	copy(key[shared:], entries[pos:pos+unshared])
+	it.key = key
+	it.value = nil
+	if valueLen > 0 {
+		it.value = entries[pos+unshared : pos+unshared+valueLen]
+	}
+	it.next = pos + unshared + valueLen
+	return true
+}
+
+func (it *blockIterator) corrupted() bool {
+	it.err = errors.Wrapf(ErrMalformedBlock, "bad entry at offset %d", it.offset)
+	it.offset, it.next = len(it.block.entries), len(it.block.entries)
+	return false
+}
+
+func (it *blockIterator) seekToRestart(idx int) {
+	it.key = nil
+	it.next = int(it.block.restarts[idx])
+}
+
+func (it *blockIterator) SeekToFirst() {
+	it.seekToRestart(0)
+	it.parseNext()
+}
+
+func (it *blockIterator) SeekToLast() {
+	it.seekToRestart(len(it.block.restarts) - 1)
+	for it.parseNext() && it.next < len(it.block.entries) {
+	}
+}
+
+// Seek moves to the first entry whose key is greater than or equal to
+// target, the restart points are searched by binary search
+func (it *blockIterator) Seek(target []byte) {
+	restarts := it.block.restarts
+
+	// the first restart point whose key is greater than or equal to
+	// target, the entries before it are smaller
+	idx := sort.Search(len(restarts), func(i int) bool {
+		it.seekToRestart(i)
+		return !it.parseNext() || it.cmp(it.key, target) != CmpLess
+	})
+	if it.err != nil {
+		return
+	}
+	if idx > 0 {
+		idx--
+	}
+
+	it.seekToRestart(idx)
+	for it.parseNext() && it.cmp(it.key, target) == CmpLess {
+	}
+}
+
+func (it *blockIterator) Next() {
+	it.parseNext()
+}
+
+// Prev scans again from the restart point before the current entry
+func (it *blockIterator) Prev() {
+	current := it.offset
+	restarts := it.block.restarts
+	idx := sort.Search(len(restarts), func(i int) bool {
+		return int(restarts[i]) >= current
+	})
+	if idx == 0 {
+		it.offset, it.next = len(it.block.entries), len(it.block.entries)
+		return
+	}
+
+	it.seekToRestart(idx - 1)
+	for it.parseNext() && it.next < current {
+	}
+}
+
+func (it *blockIterator) Key() []byte {
+	return it.key
+}
+
+func (it *blockIterator) Value() []byte {
+	return it.value
+}
+
+func (it *blockIterator) Error() error {
+	return it.err
+}
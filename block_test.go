@@ -0,0 +1,70 @@
+package rindb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlock(t *testing.T) {
+	t.Run("keys share their prefix", func(t *testing.T) {
+		builder := newBlockBuilder(16)
+		builder.add([]byte("tenant/entity/1"), []byte("1"))
+		builder.add([]byte("tenant/entity/2"), []byte("2"))
+		// 3 one byte numbers, a one byte key delta and a one byte value
+		assert.Equal(t, 3+15+1+3+1+1, builder.estimatedSize()-2*restartSize)
+	})
+
+	t.Run("seek and walk entries across restart points", func(t *testing.T) {
+		builder := newBlockBuilder(3)
+		for i := 0; i < 100; i += 2 {
+			builder.add([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%03d", i)))
+		}
+		block, err := decodeBlock(builder.finish())
+		assert.NoError(t, err)
+		assert.Len(t, block.restarts, 17)
+		assert.True(t, builder.empty())
+
+		iterator := block.newIterator(bytes.Compare)
+		for i := 0; i < 99; i++ {
+			iterator.Seek([]byte(fmt.Sprintf("key%03d", i)))
+			assert.True(t, iterator.Valid())
+			expected := i + i%2
+			assert.Equal(t, []byte(fmt.Sprintf("key%03d", expected)), iterator.Key())
+			assert.Equal(t, []byte(fmt.Sprintf("value%03d", expected)), iterator.Value())
+		}
+		iterator.Seek([]byte("key099"))
+		assert.False(t, iterator.Valid())
+
+		iterator.SeekToLast()
+		for i := 98; i >= 0; i -= 2 {
+			assert.Equal(t, []byte(fmt.Sprintf("key%03d", i)), iterator.Key())
+			iterator.Prev()
+		}
+		assert.False(t, iterator.Valid())
+
+		iterator.SeekToFirst()
+		for i := 0; i < 100; i += 2 {
+			assert.Equal(t, []byte(fmt.Sprintf("key%03d", i)), iterator.Key())
+			iterator.Next()
+		}
+		assert.False(t, iterator.Valid())
+		assert.NoError(t, iterator.Error())
+	})
+
+	t.Run("malformed blocks are rejected", func(t *testing.T) {
+		_, err := decodeBlock([]byte{1})
+		assert.ErrorIs(t, err, ErrMalformedBlock)
+		_, err = decodeBlock([]byte{0, 0, 0, 0})
+		assert.ErrorIs(t, err, ErrMalformedBlock)
+
+		block, err := decodeBlock([]byte{5, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0})
+		assert.NoError(t, err)
+		iterator := block.newIterator(bytes.Compare)
+		iterator.SeekToFirst()
+		assert.False(t, iterator.Valid())
+		assert.ErrorIs(t, iterator.Error(), ErrMalformedBlock)
+	})
+}
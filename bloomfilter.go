@@ -1,8 +1,10 @@
 package rindb
 
 import (
+	"encoding"
 	"math"
 
+	"github.com/pkg/errors"
 	"github.com/spaolacci/murmur3"
 )
 
@@ -138,6 +140,50 @@ func (b *BloomFilter) FalsePositive() float64 {
 	return P
 }
 
+var (
+	_ encoding.BinaryMarshaler   = (*BloomFilter)(nil)
+	_ encoding.BinaryUnmarshaler = (*BloomFilter)(nil)
+
+	ErrMalformedBloomFilter = errors.New("malformed bloom filter")
+)
+
+// bloomFilterHeaderSize is the size of k, m and n in a serialized filter
+const bloomFilterHeaderSize = 4 + 4 + mdByteSize
+
+// MarshalBinary serializes k, m and n followed by the words of the bitset.
+func (b *BloomFilter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, bloomFilterHeaderSize, bloomFilterHeaderSize+len(b.bucket.set)*mdByteSize)
+	byteOrder.PutUint32(data, b.config.k)
+	byteOrder.PutUint32(data[4:], b.bucket.size)
+	byteOrder.PutUint64(data[8:], b.config.n)
+	for _, word := range b.bucket.set {
+		data = byteOrder.AppendUint64(data, word)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary loads a filter serialized by MarshalBinary.
+func (b *BloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < bloomFilterHeaderSize {
+		return ErrMalformedBloomFilter
+	}
+
+	k := byteOrder.Uint32(data)
+	m := byteOrder.Uint32(data[4:])
+	n := byteOrder.Uint64(data[8:])
+	bucket := NewBitset(m)
+	if k == 0 || m == 0 || len(data) != bloomFilterHeaderSize+len(bucket.set)*mdByteSize {
+		return ErrMalformedBloomFilter
+	}
+	for idx := range bucket.set {
+		bucket.set[idx] = byteOrder.Uint64(data[bloomFilterHeaderSize+idx*mdByteSize:])
+	}
+
+	b.config = bloomFilterConfig{m: m, n: n, k: k}
+	b.bucket = bucket
+	return nil
+}
+
 // hashStr calculates the hash value of the given string using the Murmur3 algorithm.
 func hashStr(str Bytes, seed uint32) uint32 {
 	h := murmur3.New32WithSeed(seed)
@@ -89,3 +89,25 @@ func TestBloomFilterOpts(t *testing.T) {
 		assert.LessOrEqual(t, b.FalsePositive(), .1, "False positive rate too hight")
 	})
 }
+
+func TestBloomFilter_MarshalBinary(t *testing.T) {
+	b := NewBloomFilter(SetN(3), SetP(0.01), WithCalculatedM(), WithCalculatedK())
+	for _, word := range []string{"a", "b", "c"} {
+		b.Insert(Bytes(word))
+	}
+
+	data, err := b.MarshalBinary()
+	assert.NoError(t, err)
+
+	loaded := &BloomFilter{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, b.config.k, loaded.config.k)
+	assert.Equal(t, b.config.n, loaded.config.n)
+	assert.Equal(t, b.bucket, loaded.bucket)
+	for _, word := range []string{"a", "b", "c"} {
+		assert.True(t, loaded.Lookup(Bytes(word)))
+	}
+
+	assert.ErrorIs(t, loaded.UnmarshalBinary(data[:len(data)-1]), ErrMalformedBloomFilter)
+	assert.ErrorIs(t, loaded.UnmarshalBinary(nil), ErrMalformedBloomFilter)
+}
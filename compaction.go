@@ -0,0 +1,443 @@
+package rindb
+
+import (
+	"math"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// compaction merges input files of level with the files of the output
+// level overlapping them into new files of the output level
+type compaction struct {
+	level       int
+	outputLevel int
+	inputs      []SStable
+	overlaps    []SStable
+
+	// smallest and largest bound the keys of the inputs
+	smallest, largest Bytes
+
+	// dropTombstones is set when no deeper file overlaps the inputs
+	dropTombstones bool
+
+	// largestSeq is the greatest sequence number of the merged files
+	largestSeq uint64
+
+	// deleteOnly removes the inputs without writing their records
+	deleteOnly bool
+}
+
+// startCompactor runs the background compactor until hino is closed,
+// it compacts the levels every time a compaction is scheduled
+func (h *Hino) startCompactor() {
+	h.compactor.Add(1)
+	go func() {
+		defer h.compactor.Done()
+
+		// pickers depending on time are run periodically as well
+		var tick <-chan time.Time
+		if picker, ok := h.opts.CompactionPicker.(periodicPicker); ok {
+			ticker := time.NewTicker(picker.pickInterval())
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-h.closing:
+				return
+			case <-h.compactionRequests:
+			case <-tick:
+			}
+
+			if err := h.compactLevels(); err != nil {
+				h.log.ERROR("Failed to compact levels: %v", err)
+			}
+		}
+	}()
+}
+
+// scheduleCompaction wakes the background compactor up, it does not
+// block when a compaction is already scheduled
+func (h *Hino) scheduleCompaction() {
+	select {
+	case h.compactionRequests <- struct{}{}:
+	default:
+	}
+}
+
+// Compact compacts the most urgent level until no level needs it,
+// see levelScore
+func (h *Hino) Compact() error {
+	return h.compactLevels()
+}
+
+func (h *Hino) compactLevels() error {
+	h.compactionMu.Lock()
+	defer h.compactionMu.Unlock()
+
+	for {
+		select {
+		case <-h.closing:
+			return nil
+		default:
+		}
+
+		h.mu.Lock()
+		c, err := h.pickCompaction()
+		h.mu.Unlock()
+		if err != nil || c == nil {
+			return err
+		}
+
+		if err := h.runCompaction(c); err != nil {
+			return err
+		}
+	}
+}
+
+// levelTarget returns the number of bytes a level from 1 holds
+// before it needs a compaction
+func (h *Hino) levelTarget(levelNumb int) float64 {
+	target := float64(h.opts.BaseLevelSize)
+	for idx := 1; idx < levelNumb; idx++ {
+		target *= float64(h.opts.LevelSizeMultiplier)
+	}
+	return target
+}
+
+// levelScore tells how urgent the compaction of a level is, it needs one
+// from 1. Level 0 is scored by its number of files, as each of them is
+// looked up by reads, the deeper levels by their number of bytes.
+func (h *Hino) levelScore(levelNumb int) float64 {
+	level := h.levels[levelNumb]
+	if level == nil {
+		return 0
+	}
+
+	if levelNumb == 0 {
+		return float64(level.Len()) / float64(h.opts.LevelFileThreshold)
+	}
+	return float64(h.levelSize(levelNumb)) / h.levelTarget(levelNumb)
+}
+
+// levelSize returns the number of bytes of the files of a level
+func (h *Hino) levelSize(levelNumb int) int64 {
+	size := int64(0)
+	for _, fs := range h.levels[levelNumb].Values() {
+		size += h.fileSize(fs)
+	}
+	return size
+}
+
+func (h *Hino) fileSize(fs *FileSystem) int64 {
+	if meta, ok := h.metas[fs.Path()]; ok {
+		return meta.size
+	}
+
+	fileInfo, err := os.Stat(fs.Path())
+	if err != nil {
+		h.log.WARN("Failed to load file info of %s: %v", fs.Path(), err)
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+// pickCompaction returns the next compaction chosen by the picker of
+// the options, nil when the levels don't need one
+func (h *Hino) pickCompaction() (*compaction, error) {
+	picked, err := h.opts.CompactionPicker.PickCompaction(LevelsView{h})
+	if err != nil || picked == nil {
+		return nil, err
+	}
+
+	c, err := h.newCompaction(picked)
+	if err != nil {
+		return nil, err
+	}
+	for _, sstable := range append(append([]SStable{}, c.inputs...), c.overlaps...) {
+		c.largestSeq = max(c.largestSeq, h.metas[sstable.Path()].largestSeq)
+	}
+	return c, nil
+}
+
+// newCompaction checks the compaction chosen by a picker and loads its
+// files. The inputs are taken in the order of their level, so the newer
+// versions win whatever the order of the picker.
+func (h *Hino) newCompaction(picked *Compaction) (*compaction, error) {
+	if picked.Level < 0 || picked.Level >= len(h.levels) || h.levels[picked.Level] == nil ||
+		picked.OutputLevel < picked.Level || len(picked.Inputs) == 0 {
+		return nil, errors.Wrapf(ErrInvalidCompaction, "level %d to level %d with %d inputs",
+			picked.Level, picked.OutputLevel, len(picked.Inputs))
+	}
+
+	names := make(map[string]bool, len(picked.Inputs))
+	for _, input := range picked.Inputs {
+		names[input.Name] = true
+	}
+	files := make([]*FileSystem, 0, len(picked.Inputs))
+	for _, fs := range h.levels[picked.Level].Values() {
+		if names[path.Base(fs.Path())] {
+			files = append(files, fs)
+			delete(names, path.Base(fs.Path()))
+		}
+	}
+	for name := range names {
+		return nil, errors.Wrapf(ErrInvalidCompaction, "%s is not a file of level %d", name, picked.Level)
+	}
+
+	c := &compaction{
+		level:          picked.Level,
+		outputLevel:    picked.OutputLevel,
+		dropTombstones: picked.DropTombstones,
+		deleteOnly:     picked.DeleteOnly,
+	}
+	if err := h.setInputs(c, files); err != nil {
+		return nil, err
+	}
+	if c.deleteOnly {
+		return c, nil
+	}
+
+	overlaps, err := h.overlappingFiles(c.outputLevel, c.smallest, c.largest)
+	if err != nil {
+		return nil, err
+	}
+	if c.outputLevel > c.level {
+		c.overlaps, err = h.loadTables(overlaps)
+		return c, err
+	}
+
+	// the files of a level from 1 don't overlap, so the outputs must
+	// only take the place of the inputs
+	if c.level > 0 && len(overlaps) > len(files) {
+		return nil, errors.Wrapf(ErrInvalidCompaction, "inputs of level %d overlap other files", c.level)
+	}
+	return c, nil
+}
+
+// fileInfos describes files of a level to the pickers
+func (h *Hino) fileInfos(levelNumb int, files []*FileSystem) ([]FileInfo, error) {
+	infos := make([]FileInfo, 0, len(files))
+	for _, fs := range files {
+		smallest, largest, err := h.keyRange(fs)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, FileInfo{
+			Name:       path.Base(fs.Path()),
+			Level:      levelNumb,
+			Smallest:   smallest,
+			Largest:    largest,
+			Size:       h.fileSize(fs),
+			LargestSeq: h.metas[fs.Path()].largestSeq,
+			Created:    fileTime(fs),
+		})
+	}
+	return infos, nil
+}
+
+// setInputs sets the inputs of c to files and bounds their keys
+func (h *Hino) setInputs(c *compaction, files []*FileSystem) error {
+	for _, fs := range files {
+		smallest, largest, err := h.keyRange(fs)
+		if err != nil {
+			return err
+		}
+		if c.smallest == nil || Compare(smallest, c.smallest) == CmpLess {
+			c.smallest = smallest
+		}
+		if c.largest == nil || Compare(largest, c.largest) == CmpGreater {
+			c.largest = largest
+		}
+	}
+
+	inputs, err := h.loadTables(files)
+	c.inputs = inputs
+	return err
+}
+
+func (h *Hino) loadTables(files []*FileSystem) ([]SStable, error) {
+	sstables := make([]SStable, 0, len(files))
+	for _, fs := range files {
+		sstable, err := h.table(fs)
+		if err != nil {
+			return nil, err
+		}
+		sstables = append(sstables, sstable)
+	}
+	return sstables, nil
+}
+
+// runCompaction merges the inputs and their overlapping files into files
+// of the output level. The output is split at Options.TargetFileSize from
+// level 1, a file of level 0 is a whole sorted run. The new files and
+// the removal of the merged ones are committed at once, reads go on with
+// the merged files until then.
+func (h *Hino) runCompaction(c *compaction) error {
+	edit := versionEdit{}
+	for _, sstable := range c.inputs {
+		edit.deleted = append(edit.deleted, deletedFile{c.level, path.Base(sstable.Path())})
+	}
+	for _, sstable := range c.overlaps {
+		edit.deleted = append(edit.deleted, deletedFile{c.outputLevel, path.Base(sstable.Path())})
+	}
+
+	// overlaps are older than the inputs, so they are merged first
+	sources := append(append([]SStable{}, c.overlaps...), c.inputs...)
+	var outputs []SStable
+	if !c.deleteOnly {
+		var err error
+		if outputs, err = h.writeOutputs(c, sources); err != nil {
+			return err
+		}
+	}
+
+	opened := make([]*FileSystem, 0, len(outputs))
+	for _, sstable := range outputs {
+		meta, err := newFileMeta(c.outputLevel, sstable)
+		if err != nil {
+			removeTables(outputs)
+			return err
+		}
+		meta.largestSeq = c.largestSeq
+		edit.added = append(edit.added, meta)
+		opened = append(opened, sstable.FileSystem)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.logAndApply(edit, opened...); err != nil {
+		removeTables(outputs)
+		return err
+	}
+	for _, sstable := range outputs {
+		h.tables[sstable.Path()] = sstable
+	}
+	if c.level > 0 {
+		h.compactPointers[c.level] = c.largest
+	}
+
+	// remove merged sstable
+	for _, sstable := range sources {
+		if err := sstable.Close(); err != nil {
+			h.log.ERROR("Error closing file %s: %v", sstable.Path(), err)
+		}
+		if err := h.removeTable(sstable.Path()); err != nil {
+			h.log.ERROR("Error removing file %s: %v", sstable.Path(), err)
+		}
+	}
+	return nil
+}
+
+// writeOutputs merges sources into sstables of the output level, every
+// merged record may be a dropped tombstone, then nothing is written
+func (h *Hino) writeOutputs(c *compaction, sources []SStable) ([]SStable, error) {
+	output := mergeOutput{
+		newFS:      func() (*FileSystem, error) { return h.NewSSTableFS(c.outputLevel) },
+		opts:       h.tableOptions(c.outputLevel),
+		targetSize: h.opts.TargetFileSize,
+	}
+	if c.outputLevel == 0 {
+		output.targetSize = math.MaxInt
+	}
+	return mergeTables(sources, c.dropTombstones, h.snapshots.oldest(), output)
+}
+
+// removeTables removes the files of uncommitted sstables
+func removeTables(sstables []SStable) {
+	for _, sstable := range sstables {
+		_ = sstable.Close()
+		_ = os.Remove(sstable.Path())
+	}
+}
+
+// mergeOutput tells where mergeTables writes the merged records
+type mergeOutput struct {
+	newFS func() (*FileSystem, error)
+	opts  tableOptions
+
+	// targetSize is the size from which the next key goes to a new file
+	targetSize int
+}
+
+// mergeTables streams the records of sources, given from the oldest, into
+// new sstables. A version is dropped when a newer version of its key is
+// visible to every snapshot, that is when the newer one is not greater
+// than oldestSnapshot. Tombstones visible to every snapshot are dropped
+// with dropTombstones. The output rolls to a new file once it reaches the
+// target size, the versions of a key stay together so the key ranges of
+// the files don't overlap.
+func mergeTables(
+	sources []SStable, dropTombstones bool, oldestSnapshot uint64, output mergeOutput,
+) ([]SStable, error) {
+	// newer sources come first, they win over equal keys
+	children := make([]internalIterator, 0, len(sources))
+	for idx := len(sources) - 1; idx >= 0; idx-- {
+		children = append(children, sources[idx].newIterator())
+	}
+	merged := newHeapMergingIterator(children)
+	defer func() { _ = merged.Close() }()
+
+	outputs := make([]SStable, 0)
+	var writer *sstableWriter
+	fail := func(err error) ([]SStable, error) {
+		if writer != nil {
+			outputs = append(outputs, SStable{FileSystem: writer.fs})
+		}
+		removeTables(outputs)
+		return nil, err
+	}
+
+	var previous *InternalKey
+	for merged.SeekToFirst(); merged.Valid(); merged.Next() {
+		key := merged.Key()
+		sameKey := previous != nil && Compare(previous.UserKey, key.UserKey) == CmpEqual
+		if sameKey && previous.Seq == key.Seq && previous.Type == key.Type {
+			continue
+		}
+		shadowed := sameKey && previous.Seq <= oldestSnapshot
+		deleted := dropTombstones && key.Type == RecordTypeDelete && key.Seq <= oldestSnapshot
+		previous = &key
+		if shadowed || deleted {
+			continue
+		}
+
+		if writer != nil && !sameKey && writer.estimatedSize() >= output.targetSize {
+			sstable, err := writer.finish()
+			if err != nil {
+				return fail(err)
+			}
+			outputs = append(outputs, sstable)
+			writer = nil
+		}
+		if writer == nil {
+			fs, err := output.newFS()
+			if err != nil {
+				return fail(err)
+			}
+			writer = newSSTableWriter(fs, output.opts)
+		}
+
+		record := RecordImpl{Key: key.UserKey, Value: merged.Value(), Type: key.Type, Seq: key.Seq}
+		if err := writer.add(record); err != nil {
+			return fail(err)
+		}
+	}
+	if err := merged.Error(); err != nil {
+		return fail(errors.Wrap(err, "failed to merge sstables"))
+	}
+
+	if writer != nil {
+		sstable, err := writer.finish()
+		if err != nil {
+			return fail(err)
+		}
+		outputs = append(outputs, sstable)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,281 @@
+package rindb
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// commitTable writes the keys into a sstable committed to a level
+func commitTable(t *testing.T, h *Hino, levelNumb int, keys ...string) {
+	memtable := InitMemtable()
+	for _, key := range keys {
+		memtable.Put(Bytes(key), Bytes(key))
+	}
+
+	fs, err := h.NewSSTableFS(levelNumb)
+	assert.NoError(t, err)
+	sstable, err := Flush(memtable, fs)
+	assert.NoError(t, err)
+	meta, err := newFileMeta(levelNumb, sstable)
+	assert.NoError(t, err)
+	assert.NoError(t, h.logAndApply(versionEdit{added: []fileMeta{meta}}, fs))
+}
+
+// assertLeveled checks the files of every level from 1 are ordered and
+// don't overlap
+func assertLeveled(t *testing.T, h *Hino) {
+	for levelNumb := 1; levelNumb < len(h.levels); levelNumb++ {
+		var previous *fileMeta
+		for _, fs := range h.levels[levelNumb].Values() {
+			meta, ok := h.metas[fs.Path()]
+			assert.True(t, ok)
+			if previous != nil {
+				assert.Equal(t, CmpLess, Compare(previous.largest, meta.smallest),
+					"level %d: %s overlaps %s", levelNumb, previous.name, meta.name)
+			}
+			previous = &meta
+		}
+	}
+}
+
+func TestHino_pickCompaction(t *testing.T) {
+	h := newHino(t.TempDir(), &Options{LevelFileThreshold: 1})
+	defer h.Close()
+
+	commitTable(t, h, 1, "g", "h", "i")
+	commitTable(t, h, 1, "a", "b", "c")
+	commitTable(t, h, 1, "d", "e", "f")
+	commitTable(t, h, 2, "a", "z")
+	commitTable(t, h, 0, "e", "h")
+	assertLeveled(t, h)
+
+	c, err := h.pickCompaction()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, c.level)
+	assert.Len(t, c.inputs, 1)
+	assert.Equal(t, Bytes("e"), c.smallest)
+	assert.Equal(t, Bytes("h"), c.largest)
+	assert.False(t, c.dropTombstones)
+
+	overlaps := make([]Bytes, 0)
+	for _, sstable := range c.overlaps {
+		smallest, _ := sstable.KeyRange()
+		overlaps = append(overlaps, smallest)
+	}
+	assert.Equal(t, []Bytes{Bytes("d"), Bytes("g")}, overlaps)
+
+	assert.NoError(t, h.runCompaction(c))
+	assert.Equal(t, 0, h.levels[0].Len())
+	assert.Equal(t, 2, h.levels[1].Len())
+	assertLeveled(t, h)
+
+	// one file of a deeper level is picked at a time, round robin
+	h.opts.BaseLevelSize = 1
+	c, err = h.pickCompaction()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.level)
+	assert.Len(t, c.inputs, 1)
+	assert.Equal(t, Bytes("a"), c.smallest)
+	assert.Len(t, c.overlaps, 1)
+	assert.True(t, c.dropTombstones)
+
+	h.compactPointers[1] = Bytes("c")
+	c, err = h.pickCompaction()
+	assert.NoError(t, err)
+	assert.Equal(t, Bytes("d"), c.smallest)
+}
+
+func TestHino_leveledCompaction(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{
+		MemtableSize:          512,
+		LevelFileThreshold:    2,
+		BaseLevelSize:         4 << 10,
+		LevelSizeMultiplier:   2,
+		TargetFileSize:        1 << 10,
+		NoSync:                true,
+		DisableAutoCompaction: true,
+	})
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+
+	random := rand.New(rand.NewSource(1))
+	model := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%04d", random.Intn(1000))
+		if random.Intn(5) == 0 {
+			assert.NoError(t, db.Remove(Bytes(key)))
+			delete(model, key)
+		} else {
+			value := fmt.Sprintf("value%d", i)
+			assert.NoError(t, db.Put(Bytes(key), Bytes(value)))
+			model[key] = value
+		}
+
+		if i%500 == 0 {
+			db.rin.flushes.Wait()
+			assert.NoError(t, db.hino.Compact())
+			assertLeveled(t, db.hino)
+		}
+	}
+	db.rin.flushes.Wait()
+	assert.NoError(t, db.hino.Compact())
+	assertLeveled(t, db.hino)
+	assert.Greater(t, len(db.hino.levels), 2)
+	assert.Greater(t, db.hino.levels[1].Len(), 1)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		value, err := db.Get(Bytes(key))
+		if expected, ok := model[key]; ok {
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes(expected), value)
+		} else {
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+		}
+	}
+}
+
+// tempOutput writes the merged sstables to dir
+func tempOutput(t *testing.T, dir string, targetSize int) mergeOutput {
+	return mergeOutput{
+		newFS: func() (*FileSystem, error) {
+			file, err := os.CreateTemp(dir, "*"+sstableSuffix)
+			assert.NoError(t, err)
+			return NewFS(file), nil
+		},
+		opts:       defaultTableOptions(),
+		targetSize: targetSize,
+	}
+}
+
+// tableKeys returns every internal key of sstable
+func tableKeys(t *testing.T, sstable SStable) []InternalKey {
+	keys := make([]InternalKey, 0)
+	it := sstable.newIterator()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.NoError(t, it.Error())
+	return keys
+}
+
+//nolint:funlen
+func Test_mergeTables(t *testing.T) {
+	t.Run("output rolls to new files at the target size", func(t *testing.T) {
+		dir := t.TempDir()
+		fss, closer := initTempFileSystems(t, 2)
+		defer closer()
+
+		sources := make([]SStable, 0)
+		memtable := InitMemtable()
+		for _, fs := range fss {
+			for i := 0; i < 50; i++ {
+				memtable.Put(Bytes(fmt.Sprintf("key%02d", i)), Bytes("value"))
+			}
+			sstable, err := Flush(memtable, fs)
+			assert.NoError(t, err)
+			sources = append(sources, sstable)
+		}
+
+		outputs, err := mergeTables(sources, false, 0, tempOutput(t, dir, 100))
+		assert.NoError(t, err)
+		assert.Greater(t, len(outputs), 5)
+		defer removeTables(outputs)
+
+		// the versions of a key stay in the same file
+		records := 0
+		var previousLargest Bytes
+		for _, sstable := range outputs {
+			keys := tableKeys(t, sstable)
+			records += len(keys)
+			if previousLargest != nil {
+				assert.Equal(t, CmpLess, Compare(previousLargest, keys[0].UserKey))
+			}
+			previousLargest = keys[len(keys)-1].UserKey
+		}
+		assert.Equal(t, 100, records)
+	})
+
+	t.Run("versions are dropped by snapshots", func(t *testing.T) {
+		dir := t.TempDir()
+		fss, closer := initTempFileSystems(t, 1)
+		defer closer()
+
+		memtable := InitMemtable()
+		memtable.PutRecord(RecordImpl{Key: Bytes("a"), Value: Bytes("v1"), Seq: 1})
+		memtable.PutRecord(RecordImpl{Key: Bytes("a"), Value: Bytes("v2"), Seq: 2})
+		memtable.PutRecord(RecordImpl{Key: Bytes("a"), Value: Bytes("v3"), Seq: 3})
+		memtable.PutRecord(RecordImpl{Key: Bytes("b"), Type: RecordTypeDelete, Seq: 4})
+		sstable, err := Flush(memtable, fss[0])
+		assert.NoError(t, err)
+
+		versions := func(oldestSnapshot uint64) []uint64 {
+			outputs, err := mergeTables([]SStable{sstable}, true, oldestSnapshot, tempOutput(t, dir, math.MaxInt))
+			assert.NoError(t, err)
+			defer removeTables(outputs)
+
+			seqs := make([]uint64, 0)
+			for _, output := range outputs {
+				for _, key := range tableKeys(t, output) {
+					seqs = append(seqs, key.Seq)
+				}
+			}
+			return seqs
+		}
+
+		assert.Equal(t, []uint64{3}, versions(MaxSequence))
+
+		// a snapshot at 1 sees v1, one at 3 sees v3 and b
+		assert.Equal(t, []uint64{3, 2, 1, 4}, versions(1))
+		assert.Equal(t, []uint64{3, 2, 4}, versions(2))
+	})
+
+	t.Run("newer sources win over equal keys", func(t *testing.T) {
+		dir := t.TempDir()
+		fss, closer := initTempFileSystems(t, 2)
+		defer closer()
+
+		sources := make([]SStable, 0)
+		for _, value := range []string{"old", "new"} {
+			memtable := InitMemtable()
+			memtable.PutRecord(RecordImpl{Key: Bytes("a"), Value: Bytes(value), Seq: 1})
+			sstable, err := Flush(memtable, fss[len(sources)])
+			assert.NoError(t, err)
+			sources = append(sources, sstable)
+		}
+
+		outputs, err := mergeTables(sources, false, MaxSequence, tempOutput(t, dir, math.MaxInt))
+		assert.NoError(t, err)
+		defer removeTables(outputs)
+		assert.Len(t, outputs, 1)
+		assert.Len(t, tableKeys(t, outputs[0]), 1)
+
+		value, err := outputs[0].GetValue(Bytes("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("new"), value)
+	})
+
+	t.Run("nothing is written when every record is dropped", func(t *testing.T) {
+		dir := t.TempDir()
+		fss, closer := initTempFileSystems(t, 1)
+		defer closer()
+
+		memtable := InitMemtable()
+		memtable.Delete(Bytes("a"))
+		sstable, err := Flush(memtable, fss[0])
+		assert.NoError(t, err)
+
+		outputs, err := mergeTables([]SStable{sstable}, true, MaxSequence, tempOutput(t, dir, math.MaxInt))
+		assert.NoError(t, err)
+		assert.Empty(t, outputs)
+
+		dirEntries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Empty(t, dirEntries)
+	})
+}
@@ -0,0 +1,146 @@
+package rindb
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CompressionType identifies the codec of a sstable block, it is stored
+// with every block so files written with different codecs stay readable
+type CompressionType uint8
+
+const (
+	NoCompression CompressionType = iota
+	FlateCompression
+	ZlibCompression
+)
+
+var (
+	ErrUnknownCodec    = errors.New("unknown compression codec")
+	ErrCodecRegistered = errors.New("compression codec already registered")
+)
+
+// Codec compresses the data blocks of the sstables
+type Codec interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// codecs holds the registered codecs by compression type
+var codecs = struct {
+	mu     sync.RWMutex
+	byType map[CompressionType]Codec
+}{
+	byType: map[CompressionType]Codec{
+		FlateCompression: flateCodec{},
+		ZlibCompression:  zlibCodec{},
+	},
+}
+
+// RegisterCodec makes codec available to the databases under typ,
+// a type can't be registered twice
+func RegisterCodec(typ CompressionType, codec Codec) error {
+	codecs.mu.Lock()
+	defer codecs.mu.Unlock()
+
+	if _, ok := codecs.byType[typ]; ok || typ == NoCompression {
+		return errors.Wrapf(ErrCodecRegistered, "compression type %d", typ)
+	}
+	codecs.byType[typ] = codec
+	return nil
+}
+
+// codecOf returns the codec registered under typ, nil for NoCompression
+func codecOf(typ CompressionType) (Codec, error) {
+	if typ == NoCompression {
+		return nil, nil
+	}
+
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+
+	codec, ok := codecs.byType[typ]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownCodec, "compression type %d", typ)
+	}
+	return codec, nil
+}
+
+// compressBlock compresses data with the codec of typ. The data is kept
+// raw when compressing it saves less than 1/8 of its size.
+func compressBlock(data []byte, typ CompressionType) ([]byte, CompressionType, error) {
+	codec, err := codecOf(typ)
+	if err != nil || codec == nil {
+		return data, NoCompression, err
+	}
+
+	compressed, err := codec.Compress(data)
+	if err != nil {
+		return nil, NoCompression, errors.Wrap(err, "failed to compress block")
+	}
+	if len(compressed) >= len(data)-len(data)/8 {
+		return data, NoCompression, nil
+	}
+	return compressed, typ, nil
+}
+
+func decompressBlock(data []byte, typ CompressionType) ([]byte, error) {
+	codec, err := codecOf(typ)
+	if err != nil || codec == nil {
+		return data, err
+	}
+
+	raw, err := codec.Decompress(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress block")
+	}
+	return raw, nil
+}
+
+type flateCodec struct{}
+
+func (flateCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return finishCompression(&buf, w, data)
+}
+
+func (flateCodec) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type zlibCodec struct{}
+
+func (zlibCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	return finishCompression(&buf, zlib.NewWriter(&buf), data)
+}
+
+func (zlibCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func finishCompression(buf *bytes.Buffer, w io.WriteCloser, data []byte) ([]byte, error) {
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
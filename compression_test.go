@@ -0,0 +1,140 @@
+package rindb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bestZlibCodec is a custom codec compressing as much as zlib can
+type bestZlibCodec struct {
+	zlibCodec
+}
+
+const bestZlibCompression = CompressionType(100)
+
+func (bestZlibCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	return finishCompression(&buf, w, data)
+}
+
+func TestCodecs(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name": "rindb", "kind": "json"}`), 100)
+	for _, typ := range []CompressionType{FlateCompression, ZlibCompression} {
+		codec, err := codecOf(typ)
+		assert.NoError(t, err)
+
+		compressed, err := codec.Compress(data)
+		assert.NoError(t, err)
+		assert.Less(t, len(compressed), len(data)/5)
+
+		raw, err := codec.Decompress(compressed)
+		assert.NoError(t, err)
+		assert.Equal(t, data, raw)
+	}
+
+	_, err := codecOf(CompressionType(200))
+	assert.ErrorIs(t, err, ErrUnknownCodec)
+	assert.ErrorIs(t, RegisterCodec(ZlibCompression, zlibCodec{}), ErrCodecRegistered)
+	assert.ErrorIs(t, RegisterCodec(NoCompression, zlibCodec{}), ErrCodecRegistered)
+
+	// incompressible data is kept raw
+	compressed, typ, err := compressBlock([]byte("abc"), ZlibCompression)
+	assert.NoError(t, err)
+	assert.Equal(t, NoCompression, typ)
+	assert.Equal(t, []byte("abc"), compressed)
+}
+
+func jsonValue(i int) Bytes {
+	return Bytes(fmt.Sprintf(`{"id": %d, "name": "user", "tags": ["a", "b", "c"], "active": true}`, i))
+}
+
+func TestSStable_compression(t *testing.T) {
+	err := RegisterCodec(bestZlibCompression, bestZlibCodec{})
+	if err != nil {
+		assert.ErrorIs(t, err, ErrCodecRegistered)
+	}
+
+	mem := InitMemtable()
+	for i := 0; i < 500; i++ {
+		mem.Put(Bytes(fmt.Sprintf("key%03d", i)), jsonValue(i))
+	}
+
+	types := []CompressionType{NoCompression, FlateCompression, ZlibCompression, bestZlibCompression}
+	fss, closer := initTempFileSystems(t, len(types))
+	defer closer()
+
+	sizes := make([]int64, 0, len(types))
+	for idx, typ := range types {
+		opts := defaultTableOptions()
+		opts.compression = typ
+		_, err := writeSSTable(mem, fss[idx], opts)
+		assert.NoError(t, err)
+
+		sstable, err := NewSSTable(fss[idx])
+		assert.NoError(t, err)
+		assert.NoError(t, sstable.Verify())
+		for i := 0; i < 500; i += 7 {
+			value, err := sstable.GetValue(Bytes(fmt.Sprintf("key%03d", i)))
+			assert.NoError(t, err)
+			assert.Equal(t, jsonValue(i), value)
+		}
+		assert.Equal(t, 500, keyCount(t, sstable))
+
+		info, err := os.Stat(fss[idx].Path())
+		assert.NoError(t, err)
+		sizes = append(sizes, info.Size())
+	}
+	for _, size := range sizes[1:] {
+		assert.Less(t, size, sizes[0]/3)
+	}
+}
+
+func TestDB_compression(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Open(dir, &Options{Compression: []CompressionType{CompressionType(200)}})
+	assert.ErrorIs(t, err, ErrUnknownCodec)
+
+	// files written with different codecs are read together
+	write := func(compression []CompressionType, from int) {
+		db, err := Open(dir, &Options{MemtableSize: 1 << 10, NoSync: true, Compression: compression})
+		assert.NoError(t, err)
+		for i := from; i < from+100; i++ {
+			assert.NoError(t, db.Put(Bytes(fmt.Sprintf("key%03d", i)), jsonValue(i)))
+		}
+		db.rin.flushes.Wait()
+		assert.NoError(t, db.Close())
+	}
+	write(nil, 0)
+	write([]CompressionType{FlateCompression}, 100)
+	write([]CompressionType{ZlibCompression, NoCompression}, 200)
+
+	db, err := Open(dir, nil)
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+	assert.NoError(t, db.VerifyChecksums())
+	for i := 0; i < 300; i++ {
+		value, err := db.Get(Bytes(fmt.Sprintf("key%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, jsonValue(i), value)
+	}
+}
+
+func TestOptions_compressionOf(t *testing.T) {
+	opts := &Options{}
+	assert.Equal(t, NoCompression, opts.compressionOf(3))
+
+	opts.Compression = []CompressionType{NoCompression, FlateCompression, ZlibCompression}
+	assert.Equal(t, NoCompression, opts.compressionOf(0))
+	assert.Equal(t, FlateCompression, opts.compressionOf(1))
+	assert.Equal(t, ZlibCompression, opts.compressionOf(2))
+	assert.Equal(t, ZlibCompression, opts.compressionOf(5))
+}
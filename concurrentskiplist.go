@@ -0,0 +1,186 @@
+package rindb
+
+import (
+	"sync/atomic"
+)
+
+// CSLNode is a node of a ConcurrentSkipList, its links are atomic pointers
+type CSLNode[K Comparable, V any] struct {
+	Key      K
+	value    atomic.Pointer[V]
+	forwards []atomic.Pointer[CSLNode[K, V]]
+}
+
+func newCSLNode[K Comparable, V any](key K, value V, level uint) *CSLNode[K, V] {
+	node := &CSLNode[K, V]{Key: key, forwards: make([]atomic.Pointer[CSLNode[K, V]], level)}
+	node.value.Store(&value)
+	return node
+}
+
+func (n *CSLNode[K, V]) Next() *CSLNode[K, V] {
+	return n.forwards[0].Load()
+}
+
+// Value returns the latest value put for the key of the node
+func (n *CSLNode[K, V]) Value() V {
+	return *n.value.Load()
+}
+
+// ConcurrentSkipList is a skip list safe for concurrent use without locks.
+// A node is linked level by level from the bottom one with compare and
+// swap, a failed swap finds its neighbors at that level again. Readers
+// may see a node linked at the lower levels only, it's found all the same.
+// Keys are never removed, so a reached node stays in the list.
+type ConcurrentSkipList[K Comparable, V any] struct {
+	level    atomic.Uint32
+	length   atomic.Int64
+	headNote atomic.Pointer[CSLNode[K, V]]
+}
+
+func InitConcurrentSkipList[K Comparable, V any]() (*ConcurrentSkipList[K, V], error) {
+	var emptyKeyValue K
+	if err := ValidateCmpType(emptyKeyValue); err != nil {
+		return nil, err
+	}
+
+	list := &ConcurrentSkipList[K, V]{}
+	list.level.Store(DefaultLevel)
+	list.headNote.Store(newCSLNode[K, V](emptyKeyValue, *new(V), MaxLevel))
+	return list, nil
+}
+
+// Put inserts key or replaces its value
+func (list *ConcurrentSkipList[K, V]) Put(searchKey K, newValue V) {
+	head := list.Head()
+	prevs := make([]*CSLNode[K, V], MaxLevel)
+	nexts := make([]*CSLNode[K, V], MaxLevel)
+
+	newLevel := randomLevel()
+	for {
+		level := list.level.Load()
+		if newLevel <= uint(level) || list.level.CompareAndSwap(level, uint32(newLevel)) {
+			break
+		}
+	}
+
+	rn := head
+	for rl := int(list.level.Load()) - 1; rl >= 0; rl-- {
+		rn, nexts[rl] = list.findSplice(rn, searchKey, rl)
+		prevs[rl] = rn
+	}
+	if nexts[0] != nil && Compare(nexts[0].Key, searchKey) == CmpEqual {
+		nexts[0].value.Store(&newValue)
+		return
+	}
+
+	newNode := newCSLNode(searchKey, newValue, newLevel)
+	for rl := 0; rl < int(newLevel); rl++ {
+		for {
+			newNode.forwards[rl].Store(nexts[rl])
+			if prevs[rl].forwards[rl].CompareAndSwap(nexts[rl], newNode) {
+				break
+			}
+
+			// another node was linked in between, the key may be that
+			// one when it's at the bottom level
+			prevs[rl], nexts[rl] = list.findSplice(prevs[rl], searchKey, rl)
+			if rl == 0 && nexts[0] != nil && Compare(nexts[0].Key, searchKey) == CmpEqual {
+				nexts[0].value.Store(&newValue)
+				return
+			}
+		}
+	}
+	list.length.Add(1)
+}
+
+// findSplice returns the last node of a level whose key is less than
+// searchKey and the node following it, starting from rn
+func (list *ConcurrentSkipList[K, V]) findSplice(rn *CSLNode[K, V], searchKey K, level int) (*CSLNode[K, V], *CSLNode[K, V]) {
+	for {
+		next := rn.forwards[level].Load()
+		if next == nil || Compare(next.Key, searchKey) != CmpLess {
+			return rn, next
+		}
+		rn = next
+	}
+}
+
+// seekBefore returns the last node whose key is less than searchKey,
+// the head when there is no such node, and the node following it. The
+// following node is the one read by the search: reading it again could
+// return a node linked meanwhile whose key is less than searchKey.
+func (list *ConcurrentSkipList[K, V]) seekBefore(searchKey K) (*CSLNode[K, V], *CSLNode[K, V]) {
+	rn := list.Head()
+	var next *CSLNode[K, V]
+	for rl := int(list.level.Load()) - 1; rl >= 0; rl-- {
+		rn, next = list.findSplice(rn, searchKey, rl)
+	}
+	return rn, next
+}
+
+func (list *ConcurrentSkipList[K, V]) Get(searchKey K) (V, error) {
+	_, rn := list.seekBefore(searchKey)
+	if rn == nil || Compare(rn.Key, searchKey) != CmpEqual {
+		var emptyValue V
+		return emptyValue, ErrKeyNotFound
+	}
+	return rn.Value(), nil
+}
+
+// Seek returns the first node whose key is greater than or equal
+// to searchKey, nil when there is no such node
+func (list *ConcurrentSkipList[K, V]) Seek(searchKey K) *CSLNode[K, V] {
+	_, next := list.seekBefore(searchKey)
+	return next
+}
+
+// SeekBefore returns the last node whose key is less than searchKey,
+// nil when there is no such node
+func (list *ConcurrentSkipList[K, V]) SeekBefore(searchKey K) *CSLNode[K, V] {
+	rn, _ := list.seekBefore(searchKey)
+	if rn == list.Head() {
+		return nil
+	}
+	return rn
+}
+
+// Last returns the node holding the greatest key, nil when the list is empty
+func (list *ConcurrentSkipList[K, V]) Last() *CSLNode[K, V] {
+	head := list.Head()
+	rn := head
+	for rl := int(list.level.Load()) - 1; rl >= 0; rl-- {
+		for next := rn.forwards[rl].Load(); next != nil; next = rn.forwards[rl].Load() {
+			rn = next
+		}
+	}
+	if rn == head {
+		return nil
+	}
+	return rn
+}
+
+func (list *ConcurrentSkipList[K, V]) Head() *CSLNode[K, V] {
+	if list == nil || list.headNote.Load() == nil {
+		panic(ErrMalformedList)
+	}
+	return list.headNote.Load()
+}
+
+// Clear empties the list, it must not run along with Put
+func (list *ConcurrentSkipList[K, V]) Clear() {
+	if list == nil {
+		panic(ErrMalformedList)
+	}
+
+	var emptyKeyValue K
+	list.headNote.Store(newCSLNode[K, V](emptyKeyValue, *new(V), MaxLevel))
+	list.level.Store(DefaultLevel)
+	list.length.Store(0)
+}
+
+func (list *ConcurrentSkipList[K, V]) Len() uint {
+	if list == nil {
+		panic(ErrMalformedList)
+	}
+	return uint(list.length.Load())
+}
@@ -0,0 +1,177 @@
+package rindb
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertOrderedCSL[K Comparable, V any](t *testing.T, list *ConcurrentSkipList[K, V]) int {
+	count := 0
+	for node := list.Head().Next(); node != nil; node = node.Next() {
+		if next := node.Next(); next != nil && Compare(node.Key, next.Key) != CmpLess {
+			t.Errorf("Key %v comes before %v", node.Key, next.Key)
+		}
+		count++
+	}
+	return count
+}
+
+func TestConcurrentSkipList(t *testing.T) {
+	t.Run("init with not comparable keys", func(t *testing.T) {
+		_, err := InitConcurrentSkipList[struct{}, int]()
+		assert.ErrorIs(t, err, ErrUnsupportedType)
+	})
+
+	t.Run("put, get and seek", func(t *testing.T) {
+		list, err := InitConcurrentSkipList[int, int]()
+		assert.NoError(t, err)
+		assert.Nil(t, list.Seek(1))
+		assert.Nil(t, list.SeekBefore(1))
+		assert.Nil(t, list.Last())
+
+		for _, v := range []int{10, 30, 20, 0} {
+			list.Put(v, v)
+		}
+		list.Put(20, 21)
+		assert.Equal(t, uint(4), list.Len())
+		assert.Equal(t, 4, assertOrderedCSL(t, list))
+
+		value, err := list.Get(20)
+		assert.NoError(t, err)
+		assert.Equal(t, 21, value)
+		_, err = list.Get(15)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+
+		assert.Equal(t, 0, list.Seek(-1).Key)
+		assert.Equal(t, 20, list.Seek(20).Key)
+		assert.Equal(t, 30, list.Seek(21).Key)
+		assert.Nil(t, list.Seek(31))
+		assert.Nil(t, list.SeekBefore(0))
+		assert.Equal(t, 20, list.SeekBefore(21).Key)
+		assert.Equal(t, 30, list.Last().Key)
+
+		list.Clear()
+		assert.Zero(t, list.Len())
+		assert.Nil(t, list.Head().Next())
+	})
+
+	t.Run("concurrent writers and readers", func(t *testing.T) {
+		list, err := InitConcurrentSkipList[int, int]()
+		assert.NoError(t, err)
+
+		const writers, puts = 8, 500
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(2)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < puts; i++ {
+					list.Put(i*writers+w, w)
+					// every writer puts the shared keys as well
+					list.Put(-i-1, w)
+				}
+			}(w)
+
+			go func() {
+				defer wg.Done()
+				for i := 0; i < puts; i += 10 {
+					if node := list.Seek(i); node != nil {
+						assert.GreaterOrEqual(t, node.Key, i)
+					}
+					assertOrderedCSL(t, list)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, uint(writers*puts+puts), list.Len())
+		assert.Equal(t, writers*puts+puts, assertOrderedCSL(t, list))
+		for i := 0; i < writers*puts; i++ {
+			value, err := list.Get(i)
+			assert.NoError(t, err)
+			assert.Equal(t, i%writers, value)
+		}
+	})
+}
+
+// benchmarkKeys are the keys put by the benchmarks in a random order
+func benchmarkKeys(n int) []int {
+	keys := make([]int, n)
+	for idx := range keys {
+		keys[idx] = int(intn(1 << 30))
+	}
+	return keys
+}
+
+func BenchmarkSkipList_Put(b *testing.B) {
+	keys := benchmarkKeys(b.N)
+	list, _ := InitSkipList[int, int]()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Put(keys[i], i)
+	}
+}
+
+func BenchmarkConcurrentSkipList_Put(b *testing.B) {
+	keys := benchmarkKeys(b.N)
+	list, _ := InitConcurrentSkipList[int, int]()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Put(keys[i], i)
+	}
+}
+
+func BenchmarkSkipList_PutParallel(b *testing.B) {
+	list, _ := InitSkipList[int, int]()
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			list.Put(int(next.Add(1)*7919%(1<<30)), 0)
+		}
+	})
+}
+
+func BenchmarkConcurrentSkipList_PutParallel(b *testing.B) {
+	list, _ := InitConcurrentSkipList[int, int]()
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			list.Put(int(next.Add(1)*7919%(1<<30)), 0)
+		}
+	})
+}
+
+func BenchmarkSkipList_GetParallel(b *testing.B) {
+	keys := benchmarkKeys(10000)
+	list, _ := InitSkipList[int, int]()
+	for _, key := range keys {
+		list.Put(key, key)
+	}
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = list.Get(keys[next.Add(1)%int64(len(keys))])
+		}
+	})
+}
+
+func BenchmarkConcurrentSkipList_GetParallel(b *testing.B) {
+	keys := benchmarkKeys(10000)
+	list, _ := InitConcurrentSkipList[int, int]()
+	for _, key := range keys {
+		list.Put(key, key)
+	}
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = list.Get(keys[next.Add(1)%int64(len(keys))])
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package rindb
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const dbDirectoryPermission = 0o700
+
+// DB is a database living in its own directory. It owns the WAL,
+// the memtable and the sstable levels stored in that directory.
+// It is safe for concurrent use by many goroutines.
+type DB struct {
+	dir  string
+	opts *Options
+	rin  *Rin
+	hino *Hino
+}
+
+// Open opens the database stored in dir, creating the directory when it
+// does not exist. Nil opts means DefaultOptions.
+func Open(dir string, opts *Options) (*DB, error) {
+	opts = opts.withDefaults()
+	for _, compression := range opts.Compression {
+		if _, err := codecOf(compression); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, dbDirectoryPermission); err != nil {
+		return nil, errors.Wrap(err, "failed to create database directory")
+	}
+
+	hino, err := InitHino(dir, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load levels")
+	}
+
+	rin, err := InitRinDB(dir, hino, opts)
+	if err != nil {
+		hino.Close()
+		return nil, errors.Wrap(err, "failed to load WAL")
+	}
+
+	return &DB{
+		dir:  dir,
+		opts: opts,
+		rin:  rin,
+		hino: hino,
+	}, nil
+}
+
+// ReadOptions tunes a single read
+type ReadOptions struct {
+	// Snapshot freezes the read data, nil means the current state
+	Snapshot *Snapshot
+	// SkipChecksums does not check the checksums of the read blocks
+	SkipChecksums bool
+}
+
+// Get returns the value stored for key
+func (db *DB) Get(key Bytes) (Bytes, error) {
+	return db.rin.Get(key)
+}
+
+// GetWithOptions is Get tuned by opts
+func (db *DB) GetWithOptions(key Bytes, opts ReadOptions) (Bytes, error) {
+	seq := MaxSequence
+	if opts.Snapshot != nil {
+		seq = opts.Snapshot.seq
+	}
+	return db.rin.get(key, seq, opts)
+}
+
+// WriteOptions tunes a single write
+type WriteOptions struct {
+	// Sync fsyncs the WAL before the write returns, so it survives
+	// a machine crash
+	Sync bool
+	// DisableWAL does not log the write, it's lost by a crash until
+	// its memtable is flushed to level 0. DB.Close flushes it.
+	DisableWAL bool
+}
+
+// Put stores value for key, the WAL is synced unless Options.NoSync
+func (db *DB) Put(key, value Bytes) error {
+	return db.rin.Put(key, value)
+}
+
+// PutWithOptions is Put tuned by opts
+func (db *DB) PutWithOptions(key, value Bytes, opts WriteOptions) error {
+	return db.rin.put(key, value, opts)
+}
+
+// Remove deletes key, the WAL is synced unless Options.NoSync
+func (db *DB) Remove(key Bytes) error {
+	return db.rin.Remove(key)
+}
+
+// RemoveWithOptions is Remove tuned by opts
+func (db *DB) RemoveWithOptions(key Bytes, opts WriteOptions) error {
+	return db.rin.remove(key, opts)
+}
+
+// Write applies the writes of batch atomically, the WAL is synced
+// unless Options.NoSync
+func (db *DB) Write(batch *WriteBatch) error {
+	return db.rin.Write(batch)
+}
+
+// WriteWithOptions is Write tuned by opts
+func (db *DB) WriteWithOptions(batch *WriteBatch, opts WriteOptions) error {
+	return db.rin.writeBatch(batch, opts)
+}
+
+// FlushWAL writes the WAL entries kept in memory by Options.ManualWALFlush
+// to the WAL file, without fsync
+func (db *DB) FlushWAL() error {
+	return db.rin.flushWAL(false)
+}
+
+// SyncWAL writes the pending WAL entries then fsyncs the WAL, so every
+// logged write done before survives a machine crash
+func (db *DB) SyncWAL() error {
+	return db.rin.flushWAL(true)
+}
+
+// NewIterator returns an iterator over the keys of [LowerBound, UpperBound),
+// it reads the data of opts.Snapshot if set. It must be closed after use.
+func (db *DB) NewIterator(opts IterOptions) *DBIterator {
+	seq := MaxSequence
+	if opts.Snapshot != nil {
+		seq = opts.Snapshot.seq
+	}
+	return db.rin.NewIterator(seq, opts)
+}
+
+// VerifyChecksums reads every block of the live sstables, the errors
+// of the corrupted ones locate them by file and offset
+func (db *DB) VerifyChecksums() error {
+	return db.hino.VerifyChecksums()
+}
+
+// RecoveryReport lists the WAL entries dropped when the database was opened
+func (db *DB) RecoveryReport() WALRecoveryReport {
+	return db.rin.recoveryReport
+}
+
+// Close releases every file held by the database
+func (db *DB) Close() error {
+	err := db.rin.Close()
+	db.hino.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to close WAL")
+	}
+	return nil
+}
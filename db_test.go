@@ -0,0 +1,348 @@
+package rindb
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB(t *testing.T) {
+	t.Run("open creates the database directory", func(t *testing.T) {
+		dir := path.Join(t.TempDir(), "nested", "db")
+		db, err := Open(dir, nil)
+		assert.NoError(t, err)
+		assert.DirExists(t, dir)
+		assert.FileExists(t, path.Join(dir, walSegmentName(1)))
+		assert.NoError(t, db.Close())
+	})
+
+	t.Run("close twice", func(t *testing.T) {
+		db, err := Open(t.TempDir(), nil)
+		assert.NoError(t, err)
+		assert.NoError(t, db.Close())
+		assert.NoError(t, db.Close())
+	})
+
+	t.Run("reopen keeps written data", func(t *testing.T) {
+		dir := t.TempDir()
+		db, err := Open(dir, &Options{NoSync: true})
+		assert.NoError(t, err)
+		assert.NoError(t, db.Put(Bytes("key"), Bytes("value")))
+		assert.NoError(t, db.Close())
+
+		db, err = Open(dir, nil)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		value, err := db.Get(Bytes("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("value"), value)
+	})
+
+	t.Run("two databases in one process are isolated", func(t *testing.T) {
+		db1, err := Open(t.TempDir(), nil)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db1.Close()) }()
+
+		db2, err := Open(t.TempDir(), nil)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db2.Close()) }()
+
+		assert.NoError(t, db1.Put(Bytes("key"), Bytes("db1")))
+		assert.NoError(t, db2.Put(Bytes("key"), Bytes("db2")))
+
+		value, err := db1.Get(Bytes("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("db1"), value)
+
+		value, err = db2.Get(Bytes("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("db2"), value)
+	})
+}
+
+func TestDB_sequence(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MemtableSize: 64, NoSync: true}
+	db, err := Open(dir, opts)
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, db.Put(Bytes("key"), Bytes(fmt.Sprintf("value%02d", i))))
+	}
+	assert.Equal(t, uint64(20), db.rin.lastSeq)
+	assert.NoError(t, db.Close())
+
+	// flushed WALs are removed, the sequence goes on from the manifest
+	db, err = Open(dir, opts)
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+	assert.Equal(t, uint64(20), db.rin.lastSeq)
+
+	assert.NoError(t, db.Put(Bytes("key"), Bytes("latest")))
+	assert.Equal(t, uint64(21), db.rin.lastSeq)
+
+	value, err := db.Get(Bytes("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, Bytes("latest"), value)
+}
+
+func TestOptions_withDefaults(t *testing.T) {
+	opts := (*Options)(nil).withDefaults()
+	assert.Equal(t, defaultMemtableSize, opts.MemtableSize)
+	assert.Equal(t, defaultLevelFileThreshold, opts.LevelFileThreshold)
+	assert.Equal(t, int64(defaultBaseLevelSize), opts.BaseLevelSize)
+	assert.Equal(t, defaultLevelSizeMultiplier, opts.LevelSizeMultiplier)
+	assert.Equal(t, defaultTargetFileSize, opts.TargetFileSize)
+	assert.Equal(t, defaultGroupCommitMaxSize, opts.GroupCommitMaxSize)
+	assert.NotNil(t, opts.Logger)
+
+	opts = (&Options{MemtableSize: 1, NoSync: true}).withDefaults()
+	assert.Equal(t, 1, opts.MemtableSize)
+	assert.True(t, opts.NoSync)
+	assert.Equal(t, defaultLevelFileThreshold, opts.LevelFileThreshold)
+}
+
+func TestDB_recovery(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{NoSync: true})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put(Bytes("key"), Bytes("value")))
+	assert.NoError(t, db.Close())
+
+	// a crash while appending leaves a torn entry
+	file, err := os.OpenFile(path.Join(dir, walSegmentName(1)), os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = file.Write([]byte{42, 0, 0, 0, 1})
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	_, err = Open(dir, &Options{WALRecoveryMode: WALRecoveryAbsoluteConsistency})
+	assert.ErrorIs(t, err, ErrCorruptedWAL)
+
+	db, err = Open(dir, nil)
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+
+	report := db.RecoveryReport()
+	assert.Len(t, report.Corruptions, 1)
+	assert.Equal(t, int64(5), report.DroppedBytes())
+
+	value, err := db.Get(Bytes("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, Bytes("value"), value)
+}
+
+func TestDB_VerifyChecksums(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableSize: 100, NoSync: true, DisableAutoCompaction: true})
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		assert.NoError(t, db.Put(Bytes(key), Bytes("value-"+key)))
+	}
+	db.rin.flushes.Wait()
+	assert.NoError(t, db.VerifyChecksums())
+
+	fs := db.hino.levels[0].Values()[0]
+	sstable, err := db.hino.table(fs)
+	assert.NoError(t, err)
+	handle := sstable.blocks[0].handle
+	key := sstable.blocks[0].lastKey.UserKey
+	corruptValue(t, fs.Path(), handle, Bytes("value-"+string(key)))
+
+	err = db.VerifyChecksums()
+	var corruption *CorruptionError
+	assert.ErrorAs(t, err, &corruption)
+	assert.Equal(t, fs.Path(), corruption.File)
+	assert.Equal(t, handle.offset, corruption.Offset)
+
+	_, err = db.Get(key)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	_, err = db.GetWithOptions(key, ReadOptions{SkipChecksums: true})
+	assert.NoError(t, err)
+
+	it := db.NewIterator(IterOptions{})
+	it.SeekToFirst()
+	scan(it, true)
+	assert.ErrorIs(t, it.Error(), ErrChecksumMismatch)
+	assert.NoError(t, it.Close())
+}
+
+//nolint:funlen
+func TestDB_concurrency(t *testing.T) {
+	opts := &Options{MemtableSize: 1 << 10, NoSync: true, BaseLevelSize: 4 << 10, TargetFileSize: 1 << 10}
+	db, err := Open(t.TempDir(), opts)
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+
+	const writers, writes = 4, 200
+	var reading sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		reading.Add(1)
+		go func(r int) {
+			defer reading.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// both keys of a batch are seen at the same version
+				snapshot := db.GetSnapshot()
+				a, errA := snapshot.Get(Bytes(fmt.Sprintf("w%d-a", r)))
+				b, errB := snapshot.Get(Bytes(fmt.Sprintf("w%d-b", r)))
+				db.ReleaseSnapshot(snapshot)
+				assert.Equal(t, errA, errB)
+				assert.Equal(t, a, b)
+
+				it := db.NewIterator(IterOptions{})
+				var previous Bytes
+				for it.SeekToFirst(); it.Valid(); it.Next() {
+					assert.True(t, previous == nil || Compare(previous, it.Key()) == CmpLess)
+					previous = append(Bytes(nil), it.Key()...)
+				}
+				assert.NoError(t, it.Error())
+				assert.NoError(t, it.Close())
+			}
+		}(r)
+	}
+
+	var writing sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writing.Add(1)
+		go func(w int) {
+			defer writing.Done()
+			for i := 0; i < writes; i++ {
+				value := Bytes(fmt.Sprint(i))
+				batch := NewWriteBatch()
+				batch.Put(Bytes(fmt.Sprintf("w%d-a", w)), value)
+				batch.Put(Bytes(fmt.Sprintf("w%d-b", w)), value)
+				assert.NoError(t, db.Write(batch))
+				assert.NoError(t, db.Put(Bytes(fmt.Sprintf("w%d-%03d", w, i)), value))
+				if i%2 == 1 {
+					assert.NoError(t, db.Remove(Bytes(fmt.Sprintf("w%d-%03d", w, i-1))))
+				}
+			}
+		}(w)
+	}
+	writing.Wait()
+	close(done)
+	reading.Wait()
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < writes; i++ {
+			value, err := db.Get(Bytes(fmt.Sprintf("w%d-%03d", w, i)))
+			if i%2 == 0 {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+				continue
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes(fmt.Sprint(i)), value)
+		}
+		value, err := db.Get(Bytes(fmt.Sprintf("w%d-b", w)))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes(fmt.Sprint(writes-1)), value)
+	}
+}
+
+//nolint:funlen
+func TestDB_writeOptions(t *testing.T) {
+	noWAL := WriteOptions{DisableWAL: true}
+
+	t.Run("writes without WAL are lost by a crash until flushed", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{MemtableSize: 64, NoSync: true}
+		db, err := Open(dir, opts)
+		assert.NoError(t, err)
+
+		assert.NoError(t, db.PutWithOptions(Bytes("flushed"), Bytes("value"), noWAL))
+		assert.NoError(t, db.Put(Bytes("logged"), make(Bytes, 64)))
+		assert.NoError(t, db.PutWithOptions(Bytes("lost"), Bytes("value"), noWAL))
+		batch := NewWriteBatch()
+		batch.Put(Bytes("lost batch"), Bytes("value"))
+		assert.NoError(t, db.WriteWithOptions(batch, noWAL))
+		assert.NoError(t, db.RemoveWithOptions(Bytes("flushed"), noWAL))
+
+		value, err := db.Get(Bytes("lost"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("value"), value)
+		_, err = db.Get(Bytes("flushed"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+
+		// a crash leaves the memtable unflushed
+		db.rin.flushes.Wait()
+		assert.NoError(t, db.rin.wal.Close())
+		db.hino.Close()
+
+		db, err = Open(dir, opts)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		for _, key := range []string{"flushed", "logged"} {
+			_, err := db.Get(Bytes(key))
+			assert.NoError(t, err)
+		}
+		for _, key := range []string{"lost", "lost batch"} {
+			_, err := db.Get(Bytes(key))
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+		}
+	})
+
+	t.Run("a clean close keeps the writes without WAL", func(t *testing.T) {
+		dir := t.TempDir()
+		db, err := Open(dir, &Options{NoSync: true})
+		assert.NoError(t, err)
+
+		assert.NoError(t, db.PutWithOptions(Bytes("imported"), Bytes("value"), noWAL))
+		batch := NewWriteBatch()
+		batch.Put(Bytes("imported batch"), Bytes("value"))
+		assert.NoError(t, db.WriteWithOptions(batch, noWAL))
+		assert.NoError(t, db.Close())
+
+		db, err = Open(dir, nil)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		for _, key := range []string{"imported", "imported batch"} {
+			value, err := db.Get(Bytes(key))
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes("value"), value)
+		}
+		assert.Equal(t, 1, levelLen(db.hino, 0))
+	})
+
+	t.Run("manual WAL flush keeps the entries in memory", func(t *testing.T) {
+		dir := t.TempDir()
+		db, err := Open(dir, &Options{ManualWALFlush: true})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		walSize := func() int64 {
+			info, err := os.Stat(db.rin.wal.Path())
+			assert.NoError(t, err)
+			return info.Size()
+		}
+
+		assert.NoError(t, db.PutWithOptions(Bytes("a"), Bytes("a"), WriteOptions{}))
+		assert.Zero(t, walSize())
+		assert.NoError(t, db.FlushWAL())
+		size := walSize()
+		assert.NotZero(t, size)
+
+		assert.NoError(t, db.PutWithOptions(Bytes("b"), Bytes("b"), WriteOptions{}))
+		assert.Equal(t, size, walSize())
+		assert.NoError(t, db.SyncWAL())
+		assert.Equal(t, 2*size, walSize())
+
+		// writes without options are synced
+		assert.NoError(t, db.Put(Bytes("c"), Bytes("c")))
+		assert.Equal(t, 3*size, walSize())
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -16,7 +17,10 @@ var (
 	ErrFileNotOpened = errors.New("file is not opened")
 )
 
+// FileSystem is a file safe for concurrent use, mu guards the file
+// and the cursor moved by Read and Write
 type FileSystem struct {
+	mu       sync.Mutex
 	filePath string
 	file     *os.File
 }
@@ -34,11 +38,16 @@ func NewFS(file *os.File) *FileSystem {
 }
 
 func (fs *FileSystem) IsOpened() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	return fs.file != nil
 }
 
 func (fs *FileSystem) Open() error {
-	if fs.IsOpened() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file != nil {
 		WARN("File %s is already opened. Consider close and re-open again", fs.Path())
 		return nil
 	}
@@ -52,18 +61,29 @@ func (fs *FileSystem) Open() error {
 }
 
 func (fs *FileSystem) Path() string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	return fs.filePath
 }
 
 func (fs *FileSystem) Sync() error {
-	if !fs.IsOpened() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
 		return ErrFileNotOpened
 	}
 	return fs.file.Sync()
 }
 
 func (fs *FileSystem) Close() error {
-	if !fs.IsOpened() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.close()
+}
+
+func (fs *FileSystem) close() error {
+	if fs.file == nil {
 		return ErrFileNotOpened
 	}
 
@@ -76,18 +96,21 @@ func (fs *FileSystem) Close() error {
 }
 
 func (fs *FileSystem) Clean() error {
-	if err := fs.Close(); err != nil {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.close(); err != nil {
 		return errors.Wrap(err, "failed to close file system: %w")
 	}
 
-	cleanFile, err := os.OpenFile(fs.Path(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileSystemPermission)
+	cleanFile, err := os.OpenFile(fs.filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileSystemPermission)
 	if err != nil {
 		return errors.Wrap(err, "failed to clean file system: %w")
 	}
 
 	fs.file = cleanFile
 
-	if err := fs.Sync(); err != nil {
+	if err := fs.file.Sync(); err != nil {
 		return errors.Wrap(err, "failed to sync file system")
 	}
 
@@ -96,7 +119,10 @@ func (fs *FileSystem) Clean() error {
 
 // CursorPos get current cursor position in file system
 func (fs *FileSystem) CursorPos() (int64, error) {
-	if !fs.IsOpened() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
 		return 0, ErrFileNotOpened
 	}
 
@@ -108,16 +134,18 @@ func (fs *FileSystem) CursorPos() (int64, error) {
 //
 // Deprecated: no more purpose to use this function
 func (fs *FileSystem) Rename(newPath string) error {
-	if !fs.IsOpened() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
 		return ErrFileNotOpened
 	}
 
-	// TODO: add lock
 	if err := fs.file.Close(); err != nil {
 		return errors.Wrap(err, "failed to close file system: %w")
 	}
 
-	if err := os.Rename(fs.Path(), newPath); err != nil {
+	if err := os.Rename(fs.filePath, newPath); err != nil {
 		return errors.Wrap(err, "failed to rename file system: %w")
 	}
 
@@ -132,19 +160,34 @@ func (fs *FileSystem) Rename(newPath string) error {
 }
 
 func (fs *FileSystem) Write(p []byte) (int, error) {
-	if !fs.IsOpened() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
 		return 0, ErrFileNotOpened
 	}
-
-	// TODO: add lock
 	return fs.file.Write(p)
 }
 
 func (fs *FileSystem) Read(p []byte) (int, error) {
-	if !fs.IsOpened() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
 		return 0, ErrFileNotOpened
 	}
-
-	// TODO: add lock
 	return fs.file.Read(p)
 }
+
+// syncDir fsyncs a directory, so the files renamed into it are durable
+func syncDir(dir string) error {
+	file, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
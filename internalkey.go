@@ -0,0 +1,80 @@
+package rindb
+
+import (
+	"bytes"
+	"math"
+)
+
+// MaxSequence is greater than any sequence number given to a write,
+// looking a key up at MaxSequence finds its newest version
+const MaxSequence = uint64(math.MaxUint64)
+
+var _ CmpType = (*InternalKey)(nil)
+
+// InternalKey is a version of a user key. Versions are ordered by user key
+// ascending then by sequence number descending, so the newest version of
+// a key comes first.
+type InternalKey struct {
+	UserKey Bytes
+	Seq     uint64
+	Type    RecordType
+}
+
+func (k InternalKey) Compare(other any) int {
+	o, _ := other.(InternalKey)
+	if result := bytes.Compare(k.UserKey, o.UserKey); result != CmpEqual {
+		return result
+	}
+
+	switch {
+	case k.Seq > o.Seq:
+		return CmpLess
+	case k.Seq < o.Seq:
+		return CmpGreater
+	case k.Type > o.Type:
+		return CmpLess
+	case k.Type < o.Type:
+		return CmpGreater
+	default:
+		return CmpEqual
+	}
+}
+
+// internalKeyOf returns the internal key of a record
+func internalKeyOf(r Record) InternalKey {
+	return InternalKey{UserKey: r.GetKey(), Seq: r.GetSeq(), Type: r.GetType()}
+}
+
+// internalKeyTrailerSize is the size of the sequence number and the type
+// appended to the user key of an encoded internal key
+const internalKeyTrailerSize = 8
+
+// maxEncodedSequence is the greatest sequence number an encoded internal
+// key holds, greater ones like MaxSequence are encoded as it
+const maxEncodedSequence = uint64(1)<<56 - 1
+
+// encode appends the sequence number and the type to the user key, the
+// sequence number takes the 7 highest bytes of the trailer
+func (k InternalKey) encode() []byte {
+	encoded := make([]byte, len(k.UserKey)+internalKeyTrailerSize)
+	copy(encoded, k.UserKey)
+	byteOrder.PutUint64(encoded[len(k.UserKey):], min(k.Seq, maxEncodedSequence)<<8|uint64(k.Type))
+	return encoded
+}
+
+// decodeInternalKey splits an encoded internal key, the user key shares
+// the memory of encoded
+func decodeInternalKey(encoded []byte) InternalKey {
+	if len(encoded) < internalKeyTrailerSize {
+		return InternalKey{UserKey: encoded}
+	}
+
+	split := len(encoded) - internalKeyTrailerSize
+	trailer := byteOrder.Uint64(encoded[split:])
+	return InternalKey{UserKey: encoded[:split], Seq: trailer >> 8, Type: RecordType(trailer & 0xff)}
+}
+
+// compareEncodedKeys orders encoded internal keys like their InternalKey
+func compareEncodedKeys(a, b []byte) int {
+	return decodeInternalKey(a).Compare(decodeInternalKey(b))
+}
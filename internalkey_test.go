@@ -0,0 +1,60 @@
+package rindb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInternalKey_Compare(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b InternalKey
+		want CompareResult
+	}{
+		{
+			name: "user keys ascending",
+			a:    InternalKey{UserKey: Bytes("a"), Seq: 1},
+			b:    InternalKey{UserKey: Bytes("b"), Seq: 2},
+			want: CmpLess,
+		},
+		{
+			name: "newer version first",
+			a:    InternalKey{UserKey: Bytes("a"), Seq: 2},
+			b:    InternalKey{UserKey: Bytes("a"), Seq: 1},
+			want: CmpLess,
+		},
+		{
+			name: "older version last",
+			a:    InternalKey{UserKey: Bytes("a"), Seq: 1},
+			b:    InternalKey{UserKey: Bytes("a"), Seq: 2},
+			want: CmpGreater,
+		},
+		{
+			name: "same version",
+			a:    InternalKey{UserKey: Bytes("a"), Seq: 1, Type: RecordTypeDelete},
+			b:    InternalKey{UserKey: Bytes("a"), Seq: 1, Type: RecordTypeDelete},
+			want: CmpEqual,
+		},
+		{
+			name: "seek key comes before every version",
+			a:    InternalKey{UserKey: Bytes("a"), Seq: MaxSequence, Type: RecordTypeDelete},
+			b:    InternalKey{UserKey: Bytes("a"), Seq: 1 << 60},
+			want: CmpLess,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Compare(tt.a, tt.b))
+		})
+	}
+}
+
+func TestInternalKey_encode(t *testing.T) {
+	key := InternalKey{UserKey: Bytes("key"), Seq: 42, Type: RecordTypeDelete}
+	assert.Equal(t, key, decodeInternalKey(key.encode()))
+
+	newest := InternalKey{UserKey: Bytes("key"), Seq: MaxSequence, Type: RecordTypeDelete}.encode()
+	assert.Equal(t, CmpLess, compareEncodedKeys(newest, key.encode()))
+	assert.Equal(t, CmpLess, compareEncodedKeys(key.encode(), InternalKey{UserKey: Bytes("kez")}.encode()))
+}
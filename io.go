@@ -14,14 +14,31 @@ const mdByteSize = 8
 
 func ReadNumber(storage io.Reader) (uint64, error) {
 	numBytes := [mdByteSize]byte{}
-	if _, err := storage.Read(numBytes[:]); err != nil {
+	if _, err := io.ReadFull(storage, numBytes[:]); err != nil {
 		return 0, err
 	}
 
 	return byteOrder.Uint64(numBytes[:]), nil
 }
 
+var ErrUnknownRecordType = errors.New("unknown record type")
+
 func ReadRecord(storage io.Reader) (Record, error) {
+	typeBytes := [recordTypeSize]byte{}
+	if _, err := storage.Read(typeBytes[:]); err != nil {
+		return nil, errors.Wrap(err, "failed to read record type")
+	}
+
+	recordType := RecordType(typeBytes[0])
+	if recordType != RecordTypePut && recordType != RecordTypeDelete {
+		return nil, errors.Wrapf(ErrUnknownRecordType, "record type %d", recordType)
+	}
+
+	seq, err := ReadNumber(storage)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read sequence number")
+	}
+
 	keyLen, err := ReadNumber(storage)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read key length")
@@ -76,6 +93,8 @@ func ReadRecord(storage io.Reader) (Record, error) {
 	return RecordImpl{
 		Key:   keyBytes.Bytes(),
 		Value: valueBytes.Bytes(),
+		Type:  recordType,
+		Seq:   seq,
 	}, nil
 }
 
@@ -89,6 +108,14 @@ func WriteNumber(storage io.Writer, number uint64) error {
 }
 
 func WriteRecord(storage io.Writer, record Record) error {
+	if _, err := storage.Write([]byte{byte(record.GetType())}); err != nil {
+		return errors.Wrap(err, "failed to write record type")
+	}
+
+	if err := WriteNumber(storage, record.GetSeq()); err != nil {
+		return errors.Wrap(err, "failed to write sequence number")
+	}
+
 	if err := WriteNumber(storage, uint64(len(record.GetKey()))); err != nil {
 		return errors.Wrap(err, "failed to write key length")
 	}
@@ -15,7 +15,7 @@ func Test_rw(t *testing.T) {
 		testKey := ""
 		testValue := "value"
 
-		err := WriteRecord(buf, RecordImpl{Bytes(testKey), Bytes(testValue)})
+		err := WriteRecord(buf, RecordImpl{Key: Bytes(testKey), Value: Bytes(testValue)})
 		assert.NoError(t, err)
 
 		record, err := ReadRecord(buf)
@@ -30,7 +30,7 @@ func Test_rw(t *testing.T) {
 		testKey := ""
 		testValue := ""
 
-		err := WriteRecord(buf, RecordImpl{Bytes(testKey), Bytes(testValue)})
+		err := WriteRecord(buf, RecordImpl{Key: Bytes(testKey), Value: Bytes(testValue)})
 		assert.NoError(t, err)
 
 		record, err := ReadRecord(buf)
@@ -49,7 +49,7 @@ func Test_rw(t *testing.T) {
 			testValue += fmt.Sprintf("test value %d ", i)
 		}
 
-		err := WriteRecord(buf, RecordImpl{Bytes(testKey), Bytes(testValue)})
+		err := WriteRecord(buf, RecordImpl{Key: Bytes(testKey), Value: Bytes(testValue)})
 		assert.NoError(t, err)
 
 		record, err := ReadRecord(buf)
@@ -61,7 +61,7 @@ func Test_rw(t *testing.T) {
 	t.Run("write key and value with size < 255", func(t *testing.T) {
 		buf := bytes.NewBufferString("")
 
-		err := WriteRecord(buf, RecordImpl{Bytes("key"), Bytes("value")})
+		err := WriteRecord(buf, RecordImpl{Key: Bytes("key"), Value: Bytes("value")})
 		assert.NoError(t, err)
 
 		record, err := ReadRecord(buf)
@@ -69,4 +69,23 @@ func Test_rw(t *testing.T) {
 		assert.Equal(t, "key", string(record.GetKey()))
 		assert.Equal(t, "value", string(record.GetValue()))
 	})
+
+	t.Run("write tombstone", func(t *testing.T) {
+		buf := bytes.NewBufferString("")
+
+		err := WriteRecord(buf, RecordImpl{Key: Bytes("key"), Type: RecordTypeDelete})
+		assert.NoError(t, err)
+
+		record, err := ReadRecord(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "key", string(record.GetKey()))
+		assert.True(t, IsTombstone(record))
+	})
+
+	t.Run("read unknown record type", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte{0xff})
+
+		_, err := ReadRecord(buf)
+		assert.ErrorIs(t, err, ErrUnknownRecordType)
+	})
 }
@@ -0,0 +1,538 @@
+package rindb
+
+import "container/heap"
+
+// internalIterator walks the versions of the keys ordered by their
+// internal keys. Key and Value must only be called on a valid iterator.
+type internalIterator interface {
+	Valid() bool
+	SeekToFirst()
+	SeekToLast()
+	// Seek moves to the first entry whose key is greater than
+	// or equal to key
+	Seek(key InternalKey)
+	Next()
+	Prev()
+	Key() InternalKey
+	Value() Bytes
+	Error() error
+	Close() error
+}
+
+type iterDirection int
+
+const (
+	iterForward iterDirection = iota
+	iterReverse
+)
+
+var _ internalIterator = (*mergingIterator)(nil)
+
+// mergingIterator merges the entries of its children in order, every
+// step picks the smallest (or largest going backward) child key
+type mergingIterator struct {
+	children  []internalIterator
+	current   internalIterator
+	direction iterDirection
+}
+
+func newMergingIterator(children []internalIterator) *mergingIterator {
+	return &mergingIterator{children: children}
+}
+
+// Valid implements internalIterator.
+func (m *mergingIterator) Valid() bool {
+	return m.current != nil && m.current.Valid()
+}
+
+// SeekToFirst implements internalIterator.
+func (m *mergingIterator) SeekToFirst() {
+	for _, child := range m.children {
+		child.SeekToFirst()
+	}
+	m.direction = iterForward
+	m.findSmallest()
+}
+
+// SeekToLast implements internalIterator.
+func (m *mergingIterator) SeekToLast() {
+	for _, child := range m.children {
+		child.SeekToLast()
+	}
+	m.direction = iterReverse
+	m.findLargest()
+}
+
+// Seek implements internalIterator.
+func (m *mergingIterator) Seek(key InternalKey) {
+	for _, child := range m.children {
+		child.Seek(key)
+	}
+	m.direction = iterForward
+	m.findSmallest()
+}
+
+// Next implements internalIterator.
+func (m *mergingIterator) Next() {
+	// going forward after going backward, the other children are
+	// placed after the current key
+	if m.direction != iterForward {
+		key := m.current.Key()
+		for _, child := range m.children {
+			if child == m.current {
+				continue
+			}
+			child.Seek(key)
+			if child.Valid() && Compare(child.Key(), key) == CmpEqual {
+				child.Next()
+			}
+		}
+		m.direction = iterForward
+	}
+
+	m.current.Next()
+	m.findSmallest()
+}
+
+// Prev implements internalIterator.
+func (m *mergingIterator) Prev() {
+	// going backward after going forward, the other children are
+	// placed before the current key
+	if m.direction != iterReverse {
+		key := m.current.Key()
+		for _, child := range m.children {
+			if child == m.current {
+				continue
+			}
+			child.Seek(key)
+			if child.Valid() {
+				child.Prev()
+			} else {
+				child.SeekToLast()
+			}
+		}
+		m.direction = iterReverse
+	}
+
+	m.current.Prev()
+	m.findLargest()
+}
+
+// Key implements internalIterator.
+func (m *mergingIterator) Key() InternalKey {
+	return m.current.Key()
+}
+
+// Value implements internalIterator.
+func (m *mergingIterator) Value() Bytes {
+	return m.current.Value()
+}
+
+// Error implements internalIterator.
+func (m *mergingIterator) Error() error {
+	return iteratorsError(m.children)
+}
+
+// Close implements internalIterator.
+func (m *mergingIterator) Close() error {
+	return closeIterators(m.children)
+}
+
+// iteratorsError returns the first error of the iterators
+func iteratorsError(iterators []internalIterator) error {
+	for _, iterator := range iterators {
+		if err := iterator.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeIterators closes every iterator, the first error is returned
+func closeIterators(iterators []internalIterator) error {
+	var err error
+	for _, iterator := range iterators {
+		if closeErr := iterator.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (m *mergingIterator) findSmallest() {
+	m.current = nil
+	for _, child := range m.children {
+		if child.Valid() && (m.current == nil || Compare(child.Key(), m.current.Key()) == CmpLess) {
+			m.current = child
+		}
+	}
+}
+
+func (m *mergingIterator) findLargest() {
+	m.current = nil
+	for idx := len(m.children) - 1; idx >= 0; idx-- {
+		child := m.children[idx]
+		if child.Valid() && (m.current == nil || Compare(child.Key(), m.current.Key()) == CmpGreater) {
+			m.current = child
+		}
+	}
+}
+
+// heapMergingIterator merges the entries of its children forward, the
+// child with the smallest key is kept at the top of a heap. It suits
+// compactions merging many children at once. Like mergingIterator, the
+// earlier child comes first on equal keys.
+type heapMergingIterator struct {
+	children []internalIterator
+	heap     iteratorHeap
+}
+
+func newHeapMergingIterator(children []internalIterator) *heapMergingIterator {
+	return &heapMergingIterator{children: children}
+}
+
+func (m *heapMergingIterator) Valid() bool {
+	return len(m.heap) > 0
+}
+
+func (m *heapMergingIterator) SeekToFirst() {
+	m.heap = m.heap[:0]
+	for idx, child := range m.children {
+		child.SeekToFirst()
+		if child.Valid() {
+			m.heap = append(m.heap, heapItem{child, idx})
+		}
+	}
+	heap.Init(&m.heap)
+}
+
+func (m *heapMergingIterator) Next() {
+	top := m.heap[0].iterator
+	top.Next()
+	if top.Valid() {
+		heap.Fix(&m.heap, 0)
+		return
+	}
+	heap.Pop(&m.heap)
+}
+
+func (m *heapMergingIterator) Key() InternalKey {
+	return m.heap[0].iterator.Key()
+}
+
+func (m *heapMergingIterator) Value() Bytes {
+	return m.heap[0].iterator.Value()
+}
+
+func (m *heapMergingIterator) Error() error {
+	return iteratorsError(m.children)
+}
+
+func (m *heapMergingIterator) Close() error {
+	return closeIterators(m.children)
+}
+
+// heapItem is a valid child of heapMergingIterator and its position
+type heapItem struct {
+	iterator internalIterator
+	position int
+}
+
+// iteratorHeap implements heap.Interface over valid iterators
+type iteratorHeap []heapItem
+
+func (h iteratorHeap) Len() int {
+	return len(h)
+}
+
+func (h iteratorHeap) Less(i, j int) bool {
+	switch Compare(h[i].iterator.Key(), h[j].iterator.Key()) {
+	case CmpLess:
+		return true
+	case CmpEqual:
+		return h[i].position < h[j].position
+	default:
+		return false
+	}
+}
+
+func (h iteratorHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *iteratorHeap) Push(x any) {
+	*h = append(*h, x.(heapItem))
+}
+
+func (h *iteratorHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// IterOptions configures DB.NewIterator, the iterated keys are the ones
+// within every given restriction
+type IterOptions struct {
+	// LowerBound is the smallest key returned, nil means no bound
+	LowerBound Bytes
+	// UpperBound is the first key after the returned ones, nil means no bound
+	UpperBound Bytes
+	// Prefix restricts the iteration to the keys starting with it
+	Prefix Bytes
+	// PrefixSameAsStart restricts the iteration following a Seek to the
+	// keys sharing the prefix of the sought key, given by the prefix
+	// extractor of the database. Keys without prefix are not restricted.
+	PrefixSameAsStart bool
+	// Snapshot freezes the iterated data, nil means the current state
+	Snapshot *Snapshot
+	// SkipChecksums does not check the checksums of the read blocks
+	SkipChecksums bool
+}
+
+// DBIterator walks the live keys of the database in order. It only
+// returns the newest version of each key visible at its sequence number,
+// removed keys are skipped. It must be closed after use.
+type DBIterator struct {
+	iter *mergingIterator
+	seq  uint64
+	opts IterOptions
+
+	// extractor gives the prefix of the sought keys with PrefixSameAsStart
+	extractor PrefixExtractor
+
+	// base are the bounds set by the options, bounds are the ones of the
+	// current iteration. The sstable iterators share bounds to skip the
+	// sstables out of them.
+	base   iterBounds
+	bounds *iterBounds
+
+	direction iterDirection
+	valid     bool
+	err       error
+
+	// savedKey and savedValue hold the current entry going backward,
+	// iter is then placed before the versions of savedKey
+	savedKey   Bytes
+	savedValue Bytes
+}
+
+// newDBIterator returns an iterator without children, they are set once
+// built over the bounds of the iterator
+func newDBIterator(seq uint64, opts IterOptions, extractor PrefixExtractor) *DBIterator {
+	base := iterBounds{}.narrow(opts.LowerBound, opts.UpperBound).withPrefix(opts.Prefix)
+	bounds := base
+	return &DBIterator{
+		iter:      newMergingIterator(nil),
+		seq:       seq,
+		opts:      opts,
+		extractor: extractor,
+		base:      base,
+		bounds:    &bounds,
+	}
+}
+
+// Valid reports whether the iterator is at a key
+func (it *DBIterator) Valid() bool {
+	return it.valid
+}
+
+// SeekToFirst moves to the first key
+func (it *DBIterator) SeekToFirst() {
+	*it.bounds = it.base
+	if it.bounds.lower != nil {
+		it.seek(it.bounds.lower)
+		return
+	}
+
+	it.direction = iterForward
+	it.clearSaved()
+	it.iter.SeekToFirst()
+	it.findNextUserEntry(false, nil)
+}
+
+// SeekToLast moves to the last key
+func (it *DBIterator) SeekToLast() {
+	*it.bounds = it.base
+	it.direction = iterReverse
+	it.clearSaved()
+	if it.bounds.upper != nil {
+		it.iter.Seek(InternalKey{UserKey: it.bounds.upper, Seq: MaxSequence, Type: RecordTypeDelete})
+		if it.iter.Valid() {
+			it.iter.Prev()
+		} else {
+			it.iter.SeekToLast()
+		}
+	} else {
+		it.iter.SeekToLast()
+	}
+	it.findPrevUserEntry()
+}
+
+// Seek moves to the first key greater than or equal to key
+func (it *DBIterator) Seek(key Bytes) {
+	*it.bounds = it.base
+	if it.opts.PrefixSameAsStart && it.extractor != nil {
+		*it.bounds = it.base.withPrefix(it.extractor.Prefix(key))
+	}
+	it.seek(key)
+}
+
+func (it *DBIterator) seek(key Bytes) {
+	if it.bounds.lower != nil && Compare(key, it.bounds.lower) == CmpLess {
+		key = it.bounds.lower
+	}
+
+	it.direction = iterForward
+	it.clearSaved()
+	it.iter.Seek(InternalKey{UserKey: key, Seq: it.seq, Type: RecordTypeDelete})
+	it.findNextUserEntry(false, nil)
+}
+
+// Next moves to the next key
+func (it *DBIterator) Next() {
+	if !it.valid {
+		return
+	}
+
+	if it.direction == iterReverse {
+		// iter is before the versions of savedKey, they are skipped below
+		it.direction = iterForward
+		if it.iter.Valid() {
+			it.iter.Next()
+		} else {
+			it.iter.SeekToFirst()
+		}
+	} else {
+		it.savedKey = append(Bytes(nil), it.iter.Key().UserKey...)
+		it.iter.Next()
+	}
+
+	it.findNextUserEntry(true, it.savedKey)
+}
+
+// Prev moves to the previous key
+func (it *DBIterator) Prev() {
+	if !it.valid {
+		return
+	}
+
+	if it.direction == iterForward {
+		// place iter before the versions of the current key
+		it.savedKey = append(Bytes(nil), it.iter.Key().UserKey...)
+		for {
+			it.iter.Prev()
+			if !it.iter.Valid() {
+				it.valid = false
+				it.clearSaved()
+				return
+			}
+			if Compare(it.iter.Key().UserKey, it.savedKey) == CmpLess {
+				break
+			}
+		}
+		it.direction = iterReverse
+	}
+	it.findPrevUserEntry()
+}
+
+// Key returns the current key
+func (it *DBIterator) Key() Bytes {
+	if it.direction == iterReverse {
+		return it.savedKey
+	}
+	return it.iter.Key().UserKey
+}
+
+// Value returns the value of the current key
+func (it *DBIterator) Value() Bytes {
+	if it.direction == iterReverse {
+		return it.savedValue
+	}
+	return it.iter.Value()
+}
+
+// Error returns the error which stopped the iteration, if any
+func (it *DBIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.iter.Error()
+}
+
+// Close releases the files held by the iterator
+func (it *DBIterator) Close() error {
+	it.valid = false
+	return it.iter.Close()
+}
+
+func (it *DBIterator) clearSaved() {
+	it.savedKey = nil
+	it.savedValue = nil
+}
+
+// findNextUserEntry moves iter forward to the newest visible version of
+// the next live key. The versions of skipKey are skipped with skipping.
+func (it *DBIterator) findNextUserEntry(skipping bool, skipKey Bytes) {
+	for ; it.iter.Valid(); it.iter.Next() {
+		key := it.iter.Key()
+		if it.bounds.upper != nil && Compare(key.UserKey, it.bounds.upper) != CmpLess {
+			break
+		}
+		if key.Seq > it.seq {
+			continue
+		}
+		if skipping && Compare(key.UserKey, skipKey) != CmpGreater {
+			continue
+		}
+
+		if key.Type == RecordTypeDelete {
+			// the older versions of a removed key are hidden
+			skipKey = append(Bytes(nil), key.UserKey...)
+			skipping = true
+			continue
+		}
+		it.valid = true
+		it.savedKey = nil
+		return
+	}
+	it.valid = false
+	it.savedKey = nil
+}
+
+// findPrevUserEntry moves iter backward before the versions of the
+// previous live key, the key and its newest visible value are saved
+func (it *DBIterator) findPrevUserEntry() {
+	recordType := RecordTypeDelete
+	for ; it.iter.Valid(); it.iter.Prev() {
+		key := it.iter.Key()
+		if it.bounds.lower != nil && Compare(key.UserKey, it.bounds.lower) == CmpLess {
+			break
+		}
+		if key.Seq > it.seq {
+			continue
+		}
+
+		// versions are visited from the oldest one, a live key is found
+		// once an older key shows up
+		if recordType != RecordTypeDelete && Compare(key.UserKey, it.savedKey) == CmpLess {
+			break
+		}
+
+		recordType = key.Type
+		if recordType == RecordTypeDelete {
+			it.clearSaved()
+		} else {
+			it.savedKey = append(Bytes(nil), key.UserKey...)
+			it.savedValue = append(Bytes(nil), it.iter.Value()...)
+		}
+	}
+
+	if recordType == RecordTypeDelete {
+		it.valid = false
+		it.clearSaved()
+		it.direction = iterForward
+		return
+	}
+	it.valid = true
+}
@@ -0,0 +1,215 @@
+package rindb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scan returns the keys and values from the current position to the end,
+// going forward or backward
+func scan(it *DBIterator, forward bool) []string {
+	entries := make([]string, 0)
+	for it.Valid() {
+		entries = append(entries, fmt.Sprintf("%s=%s", it.Key(), it.Value()))
+		if forward {
+			it.Next()
+		} else {
+			it.Prev()
+		}
+	}
+	return entries
+}
+
+// expectedScan returns the entries of model within [lower, upper)
+func expectedScan(model map[string]string, lower, upper string, forward bool) []string {
+	keys := make([]string, 0, len(model))
+	for key := range model {
+		if (lower == "" || key >= lower) && (upper == "" || key < upper) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, fmt.Sprintf("%s=%s", key, model[key]))
+	}
+	if !forward {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return entries
+}
+
+func boundOf(key string) Bytes {
+	if key == "" {
+		return nil
+	}
+	return Bytes(key)
+}
+
+//nolint:funlen
+func TestDBIterator(t *testing.T) {
+	t.Run("empty database", func(t *testing.T) {
+		db, err := Open(t.TempDir(), &Options{NoSync: true})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		it := db.NewIterator(IterOptions{})
+		it.SeekToFirst()
+		assert.False(t, it.Valid())
+		it.SeekToLast()
+		assert.False(t, it.Valid())
+		assert.NoError(t, it.Error())
+		assert.NoError(t, it.Close())
+	})
+
+	t.Run("newest versions are merged across memtables and levels", func(t *testing.T) {
+		db, err := Open(t.TempDir(), &Options{MemtableSize: 128, LevelFileThreshold: 1, NoSync: true})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		random := rand.New(rand.NewSource(1))
+		model := make(map[string]string)
+		var snapshot *Snapshot
+		var snapshotModel map[string]string
+		for i := 0; i < 600; i++ {
+			key := fmt.Sprintf("key%02d", random.Intn(40))
+			if random.Intn(4) == 0 {
+				assert.NoError(t, db.Remove(Bytes(key)))
+				delete(model, key)
+			} else {
+				value := fmt.Sprintf("v%d", i)
+				assert.NoError(t, db.Put(Bytes(key), Bytes(value)))
+				model[key] = value
+			}
+
+			if i == 300 {
+				snapshot = db.GetSnapshot()
+				snapshotModel = make(map[string]string, len(model))
+				for k, v := range model {
+					snapshotModel[k] = v
+				}
+			}
+			if i%100 == 0 {
+				db.rin.flushes.Wait()
+				assert.NoError(t, db.hino.Compact())
+			}
+		}
+		defer db.ReleaseSnapshot(snapshot)
+
+		bounds := [][2]string{{"", ""}, {"key10", ""}, {"", "key30"}, {"key05", "key25"}, {"key050", "key051"}}
+		for _, bound := range bounds {
+			opts := IterOptions{LowerBound: boundOf(bound[0]), UpperBound: boundOf(bound[1])}
+			it := db.NewIterator(opts)
+			it.SeekToFirst()
+			assert.Equal(t, expectedScan(model, bound[0], bound[1], true), scan(it, true), bound)
+			it.SeekToLast()
+			assert.Equal(t, expectedScan(model, bound[0], bound[1], false), scan(it, false), bound)
+			assert.NoError(t, it.Error())
+			assert.NoError(t, it.Close())
+
+			opts.Snapshot = snapshot
+			it = db.NewIterator(opts)
+			it.SeekToFirst()
+			assert.Equal(t, expectedScan(snapshotModel, bound[0], bound[1], true), scan(it, true), bound)
+			assert.NoError(t, it.Close())
+		}
+	})
+
+	t.Run("seek and change direction", func(t *testing.T) {
+		db, err := Open(t.TempDir(), &Options{MemtableSize: 64, NoSync: true})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		for _, key := range []string{"a", "c", "e", "g"} {
+			assert.NoError(t, db.Put(Bytes(key), Bytes("old")))
+		}
+		db.rin.flushes.Wait()
+		assert.NoError(t, db.Put(Bytes("c"), Bytes("new")))
+		assert.NoError(t, db.Remove(Bytes("e")))
+		assert.NoError(t, db.Put(Bytes("f"), Bytes("new")))
+
+		it := db.NewIterator(IterOptions{})
+		defer func() { assert.NoError(t, it.Close()) }()
+
+		it.Seek(Bytes("b"))
+		assert.True(t, it.Valid())
+		assert.Equal(t, Bytes("c"), it.Key())
+		assert.Equal(t, Bytes("new"), it.Value())
+
+		it.Next()
+		assert.Equal(t, Bytes("f"), it.Key())
+		it.Prev()
+		assert.Equal(t, Bytes("c"), it.Key())
+		assert.Equal(t, Bytes("new"), it.Value())
+		it.Prev()
+		assert.Equal(t, Bytes("a"), it.Key())
+		it.Next()
+		assert.Equal(t, Bytes("c"), it.Key())
+		it.Next()
+		assert.Equal(t, Bytes("f"), it.Key())
+		it.Next()
+		assert.Equal(t, Bytes("g"), it.Key())
+		it.Next()
+		assert.False(t, it.Valid())
+
+		it.Seek(Bytes("h"))
+		assert.False(t, it.Valid())
+		assert.NoError(t, it.Error())
+	})
+
+	t.Run("writes after the iterator is created are not visible", func(t *testing.T) {
+		db, err := Open(t.TempDir(), &Options{NoSync: true})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		assert.NoError(t, db.Put(Bytes("a"), Bytes("a")))
+		it := db.NewIterator(IterOptions{})
+		defer func() { assert.NoError(t, it.Close()) }()
+		assert.NoError(t, db.Put(Bytes("b"), Bytes("b")))
+
+		it.SeekToFirst()
+		assert.Equal(t, []string{"a=a"}, scan(it, true))
+	})
+}
+
+func TestHeapMergingIterator(t *testing.T) {
+	children := make([]internalIterator, 0)
+	expected := make([]string, 0)
+	for child := 0; child < 5; child++ {
+		memtable := InitMemtable()
+		for i := child; i < 100; i += 5 {
+			key := fmt.Sprintf("key%03d", i)
+			memtable.PutRecord(RecordImpl{Key: Bytes(key), Value: Bytes(fmt.Sprint(child)), Seq: 1})
+			expected = append(expected, key+"="+fmt.Sprint(child))
+		}
+		// every child holds key100, the first one comes first
+		memtable.PutRecord(RecordImpl{Key: Bytes("key100"), Value: Bytes(fmt.Sprint(child)), Seq: 1})
+		expected = append(expected, "key100="+fmt.Sprint(child))
+		children = append(children, memtable.newIterator())
+	}
+	children = append(children, InitMemtable().newIterator())
+	sort.SliceStable(expected, func(i, j int) bool {
+		return expected[i][:6] < expected[j][:6]
+	})
+
+	it := newHeapMergingIterator(children)
+	defer func() { assert.NoError(t, it.Close()) }()
+
+	entries := make([]string, 0)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		entries = append(entries, fmt.Sprintf("%s=%s", it.Key().UserKey, it.Value()))
+	}
+	assert.NoError(t, it.Error())
+	assert.Equal(t, expected, entries)
+
+	// it can be restarted
+	it.SeekToFirst()
+	assert.Equal(t, Bytes("key000"), it.Key().UserKey)
+}
@@ -33,6 +33,15 @@ func (l *LinkedList[V]) Len() int {
 	return l.len
 }
 
+// Values returns the values of the list from front to back
+func (l *LinkedList[V]) Values() []V {
+	values := make([]V, 0, l.len)
+	for node := l.rootNode.next; node != nil; node = node.next {
+		values = append(values, node.Value)
+	}
+	return values
+}
+
 func (l *LinkedList[V]) Iterator() *LLIterator[V] {
 	return &LLIterator[V]{
 		runNode: l.rootNode,
@@ -91,10 +100,23 @@ func (l *LLIterator[V]) RemoveNext() error {
 	}
 	removedNode := l.runNode.next
 	l.runNode.next = removedNode.next
+	if l.list.lastNode == removedNode {
+		l.list.lastNode = l.runNode
+	}
 	l.list.len -= 1
 	return nil
 }
 
+// InsertNext inserts value after the current node, it becomes the next one
+func (l *LLIterator[V]) InsertNext(value V) {
+	node := &llNode[V]{next: l.runNode.next, Value: value}
+	l.runNode.next = node
+	if l.list.lastNode == l.runNode {
+		l.list.lastNode = node
+	}
+	l.list.len += 1
+}
+
 func (l *LLIterator[V]) PickNext() (V, error) {
 	var emptyValue V
 
@@ -152,3 +152,49 @@ func run(iterator *LLIterator[int]) {
 		fmt.Printf("iterator.Value(): %v\n", currentValue)
 	}
 }
+
+func TestLinkedListValues(t *testing.T) {
+	l := InitLinkedList[int]()
+	assert.Empty(t, l.Values())
+
+	l.PushBack(1)
+	l.PushBack(2)
+	l.PushBack(3)
+	assert.Equal(t, []int{1, 2, 3}, l.Values())
+}
+
+func TestLinkedListPushBackAfterRemovingLast(t *testing.T) {
+	l := InitLinkedList[int]()
+	l.PushBack(1)
+	l.PushBack(2)
+
+	iterator := l.Iterator()
+	_, err := iterator.PickNext()
+	assert.NoError(t, err)
+	_, err = iterator.PickNext()
+	assert.NoError(t, err)
+
+	l.PushBack(3)
+	assert.Equal(t, []int{3}, l.Values())
+}
+
+func TestLinkedListInsertNext(t *testing.T) {
+	l := InitLinkedList[int]()
+	l.Iterator().InsertNext(2)
+	l.Iterator().InsertNext(1)
+
+	iterator := l.Iterator()
+	for iterator.HasNext() {
+		_, _ = iterator.Next()
+	}
+	iterator.InsertNext(4)
+	l.PushBack(5)
+
+	iterator = l.Iterator()
+	_, _ = iterator.Next()
+	_, _ = iterator.Next()
+	iterator.InsertNext(3)
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, l.Values())
+	assert.Equal(t, 5, l.Len())
+}
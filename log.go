@@ -2,11 +2,28 @@ package rindb
 
 import "log"
 
+// Logger is the sink of the messages a database emits, *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
 func LOG(logType string, msg string, args []any) {
-	log.Printf("["+logType+"] "+msg+"\n", args...)
+	logTo(log.Default(), logType, msg, args)
+}
+
+func logTo(l Logger, logType string, msg string, args []any) {
+	l.Printf("["+logType+"] "+msg+"\n", args...)
 }
 
 func DEBUG(msg string, args ...any) { LOG("DEBUG", msg, args) }
 func INFO(msg string, args ...any)  { LOG("INFO", msg, args) }
 func WARN(msg string, args ...any)  { LOG("WARN", msg, args) }
 func ERROR(msg string, args ...any) { LOG("ERROR", msg, args) }
+
+// dbLogger routes the leveled helpers above to the logger of a database
+type dbLogger struct{ Logger }
+
+func (l dbLogger) DEBUG(msg string, args ...any) { logTo(l.Logger, "DEBUG", msg, args) }
+func (l dbLogger) INFO(msg string, args ...any)  { logTo(l.Logger, "INFO", msg, args) }
+func (l dbLogger) WARN(msg string, args ...any)  { logTo(l.Logger, "WARN", msg, args) }
+func (l dbLogger) ERROR(msg string, args ...any) { logTo(l.Logger, "ERROR", msg, args) }
@@ -0,0 +1,659 @@
+package rindb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+)
+
+/*
+The MANIFEST is an append-only log of version edits. Replaying every edit
+from the start gives the files of each level. Each edit is framed like
+the WAL entries, with its length and checksum, after manifestMagic.
+CURRENT holds the name of
+the MANIFEST in use, it's swapped by a rename so it always names a
+complete MANIFEST.
+
+A flush or a compaction writes its sstables first, then commits them by
+appending a single edit. Sstables which are not referenced by the
+MANIFEST were never committed and are removed on open. WAL segments are
+committed before they are created and forgotten by the edit of their
+flush, the segments on disk which the MANIFEST doesn't know are
+retired on open.
+*/
+
+const (
+	currentFileName = "CURRENT"
+	manifestPrefix  = "MANIFEST-"
+	sstableSuffix   = ".sst"
+
+	// manifestMagic starts the MANIFESTs whose edits are framed, the
+	// older ones hold bare records
+	manifestMagic = "RINDB-MANIFEST\n"
+)
+
+var ErrMalformedManifest = errors.New("malformed manifest")
+
+// fileMeta describes a sstable of a level
+type fileMeta struct {
+	level             int
+	name              string
+	smallest, largest Bytes
+	size              int64
+
+	// largestSeq is the greatest sequence number stored in the file,
+	// it orders the files of level 0. Zero when unknown.
+	largestSeq uint64
+}
+
+func newFileMeta(levelNumb int, sstable SStable) (fileMeta, error) {
+	fileInfo, err := os.Stat(sstable.Path())
+	if err != nil {
+		return fileMeta{}, errors.Wrap(err, "failed to load file info")
+	}
+
+	smallest, largest := sstable.KeyRange()
+	return fileMeta{
+		level:    levelNumb,
+		name:     path.Base(sstable.Path()),
+		smallest: smallest,
+		largest:  largest,
+		size:     fileInfo.Size(),
+	}, nil
+}
+
+// contains reports whether key is inside the key range of the file
+func (m fileMeta) contains(key Bytes) bool {
+	return Compare(key, m.smallest) != CmpLess && Compare(key, m.largest) != CmpGreater
+}
+
+type deletedFile struct {
+	level int
+	name  string
+}
+
+// versionEdit is a change of the levels, applied as a whole
+type versionEdit struct {
+	added   []fileMeta
+	deleted []deletedFile
+
+	// flushedWAL names the WAL whose memtable is stored in
+	// the added files, it's obsolete once the edit is committed
+	flushedWAL string
+
+	// addedWALs are the numbers of the WAL segments started
+	addedWALs []uint64
+
+	// lastSequence is the greatest sequence number stored in the levels,
+	// zero leaves it unchanged
+	lastSequence uint64
+}
+
+const (
+	editTagAddedFile uint64 = iota + 1
+	editTagDeletedFile
+	editTagFlushedWAL
+	editTagLastSequence
+
+	// editTagAddedFileSeq is editTagAddedFile followed by
+	// the largest sequence number of the file
+	editTagAddedFileSeq
+	editTagAddedWAL
+)
+
+func writeBytes(storage io.Writer, b []byte) error {
+	if err := WriteNumber(storage, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := storage.Write(b)
+	return err
+}
+
+func readBytes(storage io.Reader) (Bytes, error) {
+	size, err := ReadNumber(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make(Bytes, size)
+	if _, err := io.ReadFull(storage, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (e versionEdit) encode() (Bytes, error) {
+	buf := bytes.NewBuffer(nil)
+	for _, meta := range e.added {
+		for _, err := range []error{
+			WriteNumber(buf, editTagAddedFileSeq),
+			WriteNumber(buf, uint64(meta.level)),
+			writeBytes(buf, []byte(meta.name)),
+			writeBytes(buf, meta.smallest),
+			writeBytes(buf, meta.largest),
+			WriteNumber(buf, uint64(meta.size)),
+			WriteNumber(buf, meta.largestSeq),
+		} {
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, deleted := range e.deleted {
+		for _, err := range []error{
+			WriteNumber(buf, editTagDeletedFile),
+			WriteNumber(buf, uint64(deleted.level)),
+			writeBytes(buf, []byte(deleted.name)),
+		} {
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if e.flushedWAL != "" {
+		if err := WriteNumber(buf, editTagFlushedWAL); err != nil {
+			return nil, err
+		}
+		if err := writeBytes(buf, []byte(e.flushedWAL)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, number := range e.addedWALs {
+		if err := WriteNumber(buf, editTagAddedWAL); err != nil {
+			return nil, err
+		}
+		if err := WriteNumber(buf, number); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.lastSequence != 0 {
+		if err := WriteNumber(buf, editTagLastSequence); err != nil {
+			return nil, err
+		}
+		if err := WriteNumber(buf, e.lastSequence); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeVersionEdit(data Bytes) (versionEdit, error) {
+	edit := versionEdit{}
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		tag, err := ReadNumber(reader)
+		if err != nil {
+			return versionEdit{}, err
+		}
+
+		switch tag {
+		case editTagAddedFile, editTagAddedFileSeq:
+			meta, err := decodeAddedFile(reader)
+			if err != nil {
+				return versionEdit{}, err
+			}
+			if tag == editTagAddedFileSeq {
+				if meta.largestSeq, err = ReadNumber(reader); err != nil {
+					return versionEdit{}, err
+				}
+			}
+			edit.added = append(edit.added, meta)
+		case editTagDeletedFile:
+			level, err := ReadNumber(reader)
+			if err != nil {
+				return versionEdit{}, err
+			}
+			name, err := readBytes(reader)
+			if err != nil {
+				return versionEdit{}, err
+			}
+			edit.deleted = append(edit.deleted, deletedFile{int(level), string(name)})
+		case editTagFlushedWAL:
+			name, err := readBytes(reader)
+			if err != nil {
+				return versionEdit{}, err
+			}
+			edit.flushedWAL = string(name)
+		case editTagAddedWAL:
+			number, err := ReadNumber(reader)
+			if err != nil {
+				return versionEdit{}, err
+			}
+			edit.addedWALs = append(edit.addedWALs, number)
+		case editTagLastSequence:
+			if edit.lastSequence, err = ReadNumber(reader); err != nil {
+				return versionEdit{}, err
+			}
+		default:
+			return versionEdit{}, errors.Wrapf(ErrMalformedManifest, "unknown edit tag %d", tag)
+		}
+	}
+	return edit, nil
+}
+
+func decodeAddedFile(reader io.Reader) (fileMeta, error) {
+	level, err := ReadNumber(reader)
+	if err != nil {
+		return fileMeta{}, err
+	}
+	name, err := readBytes(reader)
+	if err != nil {
+		return fileMeta{}, err
+	}
+	smallest, err := readBytes(reader)
+	if err != nil {
+		return fileMeta{}, err
+	}
+	largest, err := readBytes(reader)
+	if err != nil {
+		return fileMeta{}, err
+	}
+	size, err := ReadNumber(reader)
+	if err != nil {
+		return fileMeta{}, err
+	}
+
+	return fileMeta{
+		level:    int(level),
+		name:     string(name),
+		smallest: smallest,
+		largest:  largest,
+		size:     int64(size),
+	}, nil
+}
+
+type manifest struct {
+	*FileSystem
+
+	// err fails every append after a failed one, whose edit
+	// may be torn in the middle of the MANIFEST
+	err error
+}
+
+// createManifest writes a new MANIFEST starting with snapshot
+// and points CURRENT to it
+func createManifest(dir string, snapshot versionEdit) (*manifest, error) {
+	fs, err := OpenFS(path.Join(dir, manifestPrefix+ulid.Make().String()))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{FileSystem: fs}
+	if _, err := fs.Write([]byte(manifestMagic)); err != nil {
+		_ = fs.Close()
+		return nil, errors.Wrap(err, "failed to write manifest")
+	}
+	if err := m.append(snapshot); err != nil {
+		_ = fs.Close()
+		return nil, err
+	}
+
+	if err := setCurrent(dir, path.Base(fs.Path())); err != nil {
+		_ = fs.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// append commits the edit, it's durable once append returns.
+// The MANIFEST is unusable after a failed append.
+func (m *manifest) append(edit versionEdit) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	data, err := edit.encode()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode version edit")
+	}
+
+	if _, err := m.file.Seek(0, io.SeekEnd); err != nil {
+		return errors.Wrap(err, "failed to seek to end of manifest")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writeFrame(buf, data)
+	if _, err := m.Write(buf.Bytes()); err != nil {
+		m.err = errors.Wrapf(err, "manifest %s is unusable after a failed write", m.Path())
+		return m.err
+	}
+	if err := m.Sync(); err != nil {
+		m.err = errors.Wrapf(err, "manifest %s is unusable after a failed sync", m.Path())
+		return m.err
+	}
+	return nil
+}
+
+func setCurrent(dir, manifestName string) error {
+	tmpPath := path.Join(dir, currentFileName+".tmp")
+	fs, err := OpenFS(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fs.Write([]byte(manifestName + "\n")); err != nil {
+		_ = fs.Close()
+		return err
+	}
+	if err := fs.Sync(); err != nil {
+		_ = fs.Close()
+		return err
+	}
+	if err := fs.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path.Join(dir, currentFileName))
+}
+
+// readCurrent returns the name of the MANIFEST in use, an empty
+// name means that the directory has no MANIFEST yet
+func readCurrent(dir string) (string, error) {
+	content, err := os.ReadFile(path.Join(dir, currentFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	manifestName := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(manifestName, manifestPrefix) {
+		return "", errors.Wrapf(ErrMalformedManifest, "CURRENT points to %q", manifestName)
+	}
+	return manifestName, nil
+}
+
+// readVersionEdits reads every committed edit of the MANIFEST, a torn
+// edit at the tail was never committed so it's ignored
+func (h *Hino) readVersionEdits(manifestPath string) ([]versionEdit, error) {
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(content, []byte(manifestMagic)) {
+		return h.readRecordEdits(manifestPath, content)
+	}
+
+	edits := make([]versionEdit, 0)
+	for offset := len(manifestMagic); offset < len(content); {
+		payload, size, reason := readFramePayload(content[offset:])
+		if reason != "" {
+			// an edit is only torn when no committed edit follows it
+			if hasVersionEdit(content[offset+1:]) {
+				return nil, errors.Wrapf(ErrMalformedManifest, "%s at offset %d of %s", reason, offset, manifestPath)
+			}
+			h.log.WARN("Ignored torn edit at the tail of %s", manifestPath)
+			break
+		}
+
+		edit, err := decodeVersionEdit(payload)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode edit of %s", manifestPath)
+		}
+		edits = append(edits, edit)
+		offset += size
+	}
+	return edits, nil
+}
+
+// hasVersionEdit reports whether a valid edit frame starts anywhere in data,
+// empty edits are ignored as zeroed bytes look like them
+func hasVersionEdit(data []byte) bool {
+	for offset := range data {
+		payload, _, reason := readFramePayload(data[offset:])
+		if reason != "" || len(payload) == 0 {
+			continue
+		}
+		if _, err := decodeVersionEdit(payload); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readRecordEdits reads a MANIFEST written before the edits were framed
+func (h *Hino) readRecordEdits(manifestPath string, content []byte) ([]versionEdit, error) {
+	edits := make([]versionEdit, 0)
+	reader := bytes.NewReader(content)
+	for reader.Len() > 0 {
+		record, err := ReadRecord(reader)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			h.log.WARN("Ignored torn edit at the tail of %s", manifestPath)
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", manifestPath)
+		}
+
+		edit, err := decodeVersionEdit(record.GetValue())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode edit of %s", manifestPath)
+		}
+		edits = append(edits, edit)
+	}
+	return edits, nil
+}
+
+// recover loads the levels from the MANIFEST named by CURRENT. A directory
+// without CURRENT is loaded from the sstable file names. Files which are
+// not part of the recovered levels are removed, then a fresh MANIFEST
+// holding the levels is created.
+func (h *Hino) recover() error {
+	manifestName, err := readCurrent(h.dir)
+	if err != nil {
+		return err
+	}
+
+	flushedWALs := make([]string, 0)
+	if manifestName == "" {
+		if err := h.LoadLevels(); err != nil {
+			return err
+		}
+		if err := h.loadFileMetas(); err != nil {
+			return err
+		}
+		// without a manifest, every segment on disk may hold writes
+		if h.liveWALs, err = listWALSegments(h.dir); err != nil {
+			return err
+		}
+	} else {
+		edits, err := h.readVersionEdits(path.Join(h.dir, manifestName))
+		if err != nil {
+			return err
+		}
+		for _, edit := range edits {
+			h.apply(edit)
+			if edit.flushedWAL != "" {
+				flushedWALs = append(flushedWALs, edit.flushedWAL)
+			}
+		}
+	}
+
+	if err := h.removeObsoleteFiles(flushedWALs); err != nil {
+		return err
+	}
+
+	m, err := createManifest(h.dir, h.snapshotEdit())
+	if err != nil {
+		return errors.Wrap(err, "failed to create manifest")
+	}
+	h.manifest = m
+
+	if manifestName != "" {
+		if err := os.Remove(path.Join(h.dir, manifestName)); err != nil {
+			h.log.WARN("Failed to remove old manifest %s: %v", manifestName, err)
+		}
+	}
+	return nil
+}
+
+// loadFileMetas builds the metadata of levels loaded from file names
+func (h *Hino) loadFileMetas() error {
+	for levelNumb, level := range h.levels {
+		if level == nil {
+			continue
+		}
+
+		for _, fs := range level.Values() {
+			sstable, err := h.table(fs)
+			if err != nil {
+				return errors.Wrapf(err, "failed to load sstable %s", fs.Path())
+			}
+
+			meta, err := newFileMeta(levelNumb, sstable)
+			if err != nil {
+				return err
+			}
+			h.metas[fs.Path()] = meta
+
+			// the sequence numbers of the files are only known by reading them
+			iterator, err := sstable.Iterator()
+			if err != nil {
+				return err
+			}
+			for iterator.HasNext() {
+				record, err := iterator.Next()
+				if err != nil {
+					return err
+				}
+				h.lastSequence = max(h.lastSequence, record.GetSeq())
+			}
+		}
+	}
+	return nil
+}
+
+// removeObsoleteFiles removes sstables which were never committed and
+// retires WALs which were already flushed, then the expired archived WALs
+func (h *Hino) removeObsoleteFiles(flushedWALs []string) error {
+	dirEntries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, dirEntry := range dirEntries {
+		filePath := path.Join(h.dir, dirEntry.Name())
+		if number, ok := parseWALSegment(dirEntry.Name()); ok && !h.isLiveWAL(number) {
+			flushedWALs = append(flushedWALs, dirEntry.Name())
+			continue
+		}
+		if _, ok := h.metas[filePath]; ok || !strings.HasSuffix(filePath, sstableSuffix) {
+			continue
+		}
+
+		h.log.WARN("Removing sstable %s, it's not part of the manifest", filePath)
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+	}
+
+	for _, walName := range flushedWALs {
+		if err := retireWAL(path.Join(h.dir, walName), h.opts); err != nil {
+			return err
+		}
+	}
+	return purgeWALArchive(h.opts)
+}
+
+func (h *Hino) isLiveWAL(number uint64) bool {
+	for _, live := range h.liveWALs {
+		if live == number {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotEdit returns an edit adding every file of the levels
+// and every live WAL segment
+func (h *Hino) snapshotEdit() versionEdit {
+	edit := versionEdit{lastSequence: h.lastSequence}
+	edit.addedWALs = append(edit.addedWALs, h.liveWALs...)
+	for _, level := range h.levels {
+		if level == nil {
+			continue
+		}
+		for _, fs := range level.Values() {
+			edit.added = append(edit.added, h.metas[fs.Path()])
+		}
+	}
+	return edit
+}
+
+// logAndApply commits the edit to the MANIFEST then applies it to the
+// levels. opened are the already opened file systems of added files.
+// h.mu must be held.
+func (h *Hino) logAndApply(edit versionEdit, opened ...*FileSystem) error {
+	if h.manifest != nil {
+		if err := h.manifest.append(edit); err != nil {
+			return errors.Wrap(err, "failed to commit version edit")
+		}
+	}
+
+	h.apply(edit, opened...)
+	return nil
+}
+
+func (h *Hino) apply(edit versionEdit, opened ...*FileSystem) {
+	h.lastSequence = max(h.lastSequence, edit.lastSequence)
+
+	for _, deleted := range edit.deleted {
+		filePath := path.Join(h.dir, deleted.name)
+		if deleted.level < len(h.levels) && h.levels[deleted.level] != nil {
+			removeFile(h.levels[deleted.level], filePath)
+		}
+		delete(h.metas, filePath)
+		delete(h.tables, filePath)
+	}
+
+	for _, meta := range edit.added {
+		filePath := path.Join(h.dir, meta.name)
+		fs := &FileSystem{filePath: filePath}
+		for _, openedFs := range opened {
+			if openedFs.Path() == filePath {
+				fs = openedFs
+			}
+		}
+		h.metas[filePath] = meta
+		h.addFile(meta.level, fs)
+	}
+
+	for _, number := range edit.addedWALs {
+		if !h.isLiveWAL(number) {
+			h.liveWALs = append(h.liveWALs, number)
+		}
+	}
+	if number, ok := parseWALSegment(edit.flushedWAL); ok {
+		liveWALs := make([]uint64, 0, len(h.liveWALs))
+		for _, live := range h.liveWALs {
+			if live != number {
+				liveWALs = append(liveWALs, live)
+			}
+		}
+		h.liveWALs = liveWALs
+	}
+}
+
+func removeFile(level *LinkedList[*FileSystem], filePath string) {
+	levelIterator := level.Iterator()
+	for levelIterator.HasNext() {
+		fs, err := levelIterator.NextValue()
+		if err != nil {
+			return
+		}
+		if fs.Path() == filePath {
+			_ = levelIterator.RemoveNext()
+			return
+		}
+		_, _ = levelIterator.Next()
+	}
+}
@@ -0,0 +1,234 @@
+package rindb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_versionEdit(t *testing.T) {
+	edit := versionEdit{
+		added: []fileMeta{
+			{level: 0, name: "l00_a.sst", smallest: Bytes("a"), largest: Bytes("c"), size: 42, largestSeq: 9},
+			{level: 2, name: "l02_b.sst", smallest: Bytes(""), largest: Bytes("z"), size: 7},
+		},
+		deleted:    []deletedFile{{1, "l01_c.sst"}},
+		flushedWAL: walSegmentName(3),
+		addedWALs:  []uint64{4, 5},
+	}
+
+	data, err := edit.encode()
+	assert.NoError(t, err)
+
+	got, err := decodeVersionEdit(data)
+	assert.NoError(t, err)
+	assert.Equal(t, edit.deleted, got.deleted)
+	assert.Equal(t, edit.flushedWAL, got.flushedWAL)
+	assert.Equal(t, edit.addedWALs, got.addedWALs)
+	assert.Len(t, got.added, 2)
+	assert.Equal(t, edit.added[0], got.added[0])
+	assert.Equal(t, Bytes("z"), got.added[1].largest)
+
+	_, err = decodeVersionEdit(Bytes{0xff, 0, 0, 0, 0, 0, 0, 0})
+	assert.ErrorIs(t, err, ErrMalformedManifest)
+
+	// files added before sequence numbers were recorded
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, WriteNumber(buf, editTagAddedFile))
+	assert.NoError(t, WriteNumber(buf, 1))
+	assert.NoError(t, writeBytes(buf, []byte("l01_e.sst")))
+	assert.NoError(t, writeBytes(buf, Bytes("a")))
+	assert.NoError(t, writeBytes(buf, Bytes("b")))
+	assert.NoError(t, WriteNumber(buf, 3))
+	got, err = decodeVersionEdit(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, []fileMeta{{level: 1, name: "l01_e.sst", smallest: Bytes("a"), largest: Bytes("b"), size: 3}}, got.added)
+}
+
+func Test_readVersionEdits(t *testing.T) {
+	dir := t.TempDir()
+	m, err := createManifest(dir, versionEdit{})
+	assert.NoError(t, err)
+	defer func() { _ = m.Close() }()
+
+	manifestName, err := readCurrent(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, path.Base(m.Path()), manifestName)
+
+	assert.NoError(t, m.append(versionEdit{deleted: []deletedFile{{0, "l00_a.sst"}}}))
+
+	// a torn edit at the tail is not committed
+	_, err = m.Write([]byte{byte(RecordTypePut), 1, 2, 3})
+	assert.NoError(t, err)
+
+	edits, err := newHino(dir, nil).readVersionEdits(m.Path())
+	assert.NoError(t, err)
+	assert.Len(t, edits, 2)
+	assert.Equal(t, "l00_a.sst", edits[1].deleted[0].name)
+
+	// a corrupted length in the middle is not a torn tail, the
+	// second edit follows the empty snapshot
+	assert.NoError(t, m.append(versionEdit{flushedWAL: walSegmentName(1)}))
+	corrupted, err := os.ReadFile(m.Path())
+	assert.NoError(t, err)
+	corrupted[len(manifestMagic)+walFrameHeaderSize+1] = 0xaa
+	corruptedPath := path.Join(dir, manifestPrefix+"corrupted")
+	assert.NoError(t, os.WriteFile(corruptedPath, corrupted, 0o644))
+	_, err = newHino(dir, nil).readVersionEdits(corruptedPath)
+	assert.ErrorIs(t, err, ErrMalformedManifest)
+
+	// MANIFESTs written before the edits were framed
+	legacy := bytes.NewBuffer(nil)
+	for _, edit := range []versionEdit{{}, {flushedWAL: walSegmentName(2)}} {
+		data, err := edit.encode()
+		assert.NoError(t, err)
+		assert.NoError(t, WriteRecord(legacy, RecordImpl{Value: data}))
+	}
+	legacyPath := path.Join(dir, manifestPrefix+"legacy")
+	assert.NoError(t, os.WriteFile(legacyPath, legacy.Bytes(), 0o644))
+	edits, err = newHino(dir, nil).readVersionEdits(legacyPath)
+	assert.NoError(t, err)
+	assert.Len(t, edits, 2)
+	assert.Equal(t, walSegmentName(2), edits[1].flushedWAL)
+}
+
+func Test_manifestAppend(t *testing.T) {
+	dir := t.TempDir()
+	m, err := createManifest(dir, versionEdit{})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Close())
+
+	// writes to a read only file fail
+	file, err := os.Open(m.Path())
+	assert.NoError(t, err)
+	defer func() { _ = file.Close() }()
+	m = &manifest{FileSystem: NewFS(file)}
+	assert.Error(t, m.append(versionEdit{flushedWAL: walSegmentName(1)}))
+
+	// the manifest is unusable from then on
+	m.FileSystem = nil
+	assert.ErrorContains(t, m.append(versionEdit{}), "unusable")
+}
+
+//nolint:funlen
+func TestHino_recover(t *testing.T) {
+	t.Run("levels are loaded from the manifest", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{MemtableSize: 64, NoSync: true, DisableAutoCompaction: true}
+		db, err := Open(dir, opts)
+		assert.NoError(t, err)
+		for i := 0; i < 50; i++ {
+			assert.NoError(t, db.Put(Bytes(fmt.Sprintf("key%03d", i)), Bytes("value")))
+		}
+		assert.NoError(t, db.Close())
+		assert.FileExists(t, path.Join(dir, currentFileName))
+
+		// an sstable which was never committed
+		orphan, err := OpenFS(path.Join(dir, "l00_orphan"+sstableSuffix))
+		assert.NoError(t, err)
+		assert.NoError(t, orphan.Close())
+
+		db, err = Open(dir, opts)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		assert.NoFileExists(t, orphan.Path())
+		assert.Greater(t, db.hino.levels[0].Len(), 1)
+		for _, fs := range db.hino.levels[0].Values() {
+			meta, ok := db.hino.metas[fs.Path()]
+			assert.True(t, ok)
+			assert.NotZero(t, meta.size)
+		}
+
+		for i := 0; i < 50; i++ {
+			value, err := db.Get(Bytes(fmt.Sprintf("key%03d", i)))
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes("value"), value)
+		}
+
+		manifests := 0
+		dirEntries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		for _, dirEntry := range dirEntries {
+			if strings.HasPrefix(dirEntry.Name(), manifestPrefix) {
+				manifests++
+			}
+		}
+		assert.Equal(t, 1, manifests)
+	})
+
+	t.Run("directory without manifest is loaded from file names", func(t *testing.T) {
+		dir := t.TempDir()
+		h := newHino(dir, nil)
+		flushToLevel(t, h, 0, "a", "1")
+		flushToLevel(t, h, 1, "b", "2")
+		h.Close()
+
+		h, err := InitHino(dir, nil)
+		assert.NoError(t, err)
+		defer h.Close()
+
+		assert.Equal(t, 1, h.levels[0].Len())
+		assert.Equal(t, 1, h.levels[1].Len())
+		assert.FileExists(t, path.Join(dir, currentFileName))
+
+		record, err := h.searchKey(Bytes("b"), MaxSequence, true)
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("2"), record.GetValue())
+	})
+
+	t.Run("flushed WAL left by a crash is removed", func(t *testing.T) {
+		dir := t.TempDir()
+		walPath := path.Join(dir, frozenWALPrefix+"flushed")
+		fs, err := OpenFS(walPath)
+		assert.NoError(t, err)
+		assert.NoError(t, fs.Close())
+
+		m, err := createManifest(dir, versionEdit{flushedWAL: path.Base(walPath)})
+		assert.NoError(t, err)
+		assert.NoError(t, m.Close())
+
+		h, err := InitHino(dir, nil)
+		assert.NoError(t, err)
+		defer h.Close()
+		assert.NoFileExists(t, walPath)
+	})
+
+	t.Run("live WAL segments are kept, the others are retired", func(t *testing.T) {
+		dir := t.TempDir()
+		for number := uint64(1); number <= 4; number++ {
+			fs, err := OpenFS(path.Join(dir, walSegmentName(number)))
+			assert.NoError(t, err)
+			assert.NoError(t, fs.Close())
+		}
+
+		m, err := createManifest(dir, versionEdit{addedWALs: []uint64{1, 2, 3}})
+		assert.NoError(t, err)
+		assert.NoError(t, m.append(versionEdit{flushedWAL: walSegmentName(1)}))
+		assert.NoError(t, m.Close())
+
+		opts := &Options{WALArchiveDir: path.Join(dir, "archive")}
+		h, err := InitHino(dir, opts)
+		assert.NoError(t, err)
+		h.Close()
+		assert.Equal(t, []uint64{2, 3}, h.liveWALs)
+
+		numbers, err := listWALSegments(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{2, 3}, numbers)
+		numbers, err = listWALSegments(opts.WALArchiveDir)
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{1, 4}, numbers)
+
+		// the fresh manifest keeps the live segments
+		h, err = InitHino(dir, nil)
+		assert.NoError(t, err)
+		defer h.Close()
+		assert.Equal(t, []uint64{2, 3}, h.walSegments())
+	})
+}
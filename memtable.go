@@ -2,6 +2,7 @@ package rindb
 
 import (
 	"bytes"
+	"sync/atomic"
 )
 
 var _ CmpType = (*Bytes)(nil)
@@ -13,27 +14,166 @@ func (b Bytes) Compare(other any) int {
 	return bytes.Compare(b, o)
 }
 
+// Memtable keeps every version of the keys put into it, ordered by
+// their internal keys. Records may be put by many goroutines at once
+// while others read it, no lock is taken.
 type Memtable struct {
-	data *SkipList[Bytes, Bytes]
+	data *ConcurrentSkipList[InternalKey, Bytes]
+
+	// size is the approximate number of bytes put into the memtable
+	size *atomic.Int64
+
+	// lastSeq is the greatest sequence number put into the memtable
+	lastSeq *atomic.Uint64
 }
 
-func toRecord(node *SLNode[Bytes, Bytes]) Record {
-	return RecordImpl{node.Key, node.Value}
+func toRecord(node *CSLNode[InternalKey, Bytes]) Record {
+	return RecordImpl{
+		Key:   node.Key.UserKey,
+		Value: node.Value(),
+		Type:  node.Key.Type,
+		Seq:   node.Key.Seq,
+	}
 }
 
 func InitMemtable() Memtable {
-	list, _ := InitSkipList[Bytes, Bytes]()
-	return Memtable{data: list}
+	list, _ := InitConcurrentSkipList[InternalKey, Bytes]()
+	return Memtable{data: list, size: new(atomic.Int64), lastSeq: new(atomic.Uint64)}
 }
 
+// Get returns the value of key, ErrKeyNotFound when the key is deleted
 func (m Memtable) Get(key Bytes) (Bytes, error) {
-	return m.data.Get(key)
+	record, err := m.lookup(key, MaxSequence)
+	if err != nil {
+		return nil, err
+	}
+	if IsTombstone(record) {
+		return nil, ErrKeyNotFound
+	}
+	return record.GetValue(), nil
+}
+
+// lookup returns the newest version of key whose sequence number is not
+// greater than seq, it may be a tombstone
+func (m Memtable) lookup(key Bytes, seq uint64) (Record, error) {
+	node := m.data.Seek(InternalKey{UserKey: key, Seq: seq, Type: RecordTypeDelete})
+	if node == nil || Compare(node.Key.UserKey, key) != CmpEqual {
+		return nil, ErrKeyNotFound
+	}
+	return toRecord(node), nil
+}
+
+// userKeys returns the keys of [start, end) having a version in the memtable
+func (m Memtable) userKeys(start, end Bytes) []Bytes {
+	keys := make([]Bytes, 0)
+	node := m.data.Seek(InternalKey{UserKey: start, Seq: MaxSequence, Type: RecordTypeDelete})
+	for ; node != nil && Compare(node.Key.UserKey, end) == CmpLess; node = node.Next() {
+		if len(keys) == 0 || Compare(keys[len(keys)-1], node.Key.UserKey) != CmpEqual {
+			keys = append(keys, node.Key.UserKey)
+		}
+	}
+	return keys
 }
 
+// Put puts a new version of key, its sequence number follows
+// the last one of the memtable
 func (m Memtable) Put(key, value Bytes) {
-	m.data.Put(key, value)
+	m.PutRecord(RecordImpl{Key: key, Value: value, Seq: m.LastSeq() + 1})
+}
+
+// Delete puts a tombstone for key
+func (m Memtable) Delete(key Bytes) {
+	m.PutRecord(RecordImpl{Key: key, Type: RecordTypeDelete, Seq: m.LastSeq() + 1})
+}
+
+// PutRecord puts a value or a tombstone depending on the record type,
+// at the sequence number of the record
+func (m Memtable) PutRecord(record Record) {
+	m.data.Put(internalKeyOf(record), record.GetValue())
+	m.size.Add(int64(record.GetSize()))
+	for {
+		lastSeq := m.lastSeq.Load()
+		if record.GetSeq() <= lastSeq || m.lastSeq.CompareAndSwap(lastSeq, record.GetSeq()) {
+			break
+		}
+	}
+}
+
+// Size returns the approximate number of bytes held by the memtable,
+// overwritten values are still counted.
+func (m Memtable) Size() int {
+	return int(m.size.Load())
+}
+
+// LastSeq returns the greatest sequence number put into the memtable,
+// it's kept when the memtable is cleared.
+func (m Memtable) LastSeq() uint64 {
+	return m.lastSeq.Load()
 }
 
 func (m Memtable) Clear() {
 	m.data.Clear()
+	m.size.Store(0)
+}
+
+var _ internalIterator = (*memtableIterator)(nil)
+
+// memtableIterator walks every version held by a memtable
+type memtableIterator struct {
+	data *ConcurrentSkipList[InternalKey, Bytes]
+	node *CSLNode[InternalKey, Bytes]
+}
+
+func (m Memtable) newIterator() *memtableIterator {
+	return &memtableIterator{data: m.data}
+}
+
+// Valid implements internalIterator.
+func (m *memtableIterator) Valid() bool {
+	return m.node != nil
+}
+
+// SeekToFirst implements internalIterator.
+func (m *memtableIterator) SeekToFirst() {
+	m.node = m.data.Head().Next()
+}
+
+// SeekToLast implements internalIterator.
+func (m *memtableIterator) SeekToLast() {
+	m.node = m.data.Last()
+}
+
+// Seek implements internalIterator.
+func (m *memtableIterator) Seek(key InternalKey) {
+	m.node = m.data.Seek(key)
+}
+
+// Next implements internalIterator.
+func (m *memtableIterator) Next() {
+	m.node = m.node.Next()
+}
+
+// Prev implements internalIterator.
+func (m *memtableIterator) Prev() {
+	m.node = m.data.SeekBefore(m.node.Key)
+}
+
+// Key implements internalIterator.
+func (m *memtableIterator) Key() InternalKey {
+	return m.node.Key
+}
+
+// Value implements internalIterator.
+func (m *memtableIterator) Value() Bytes {
+	return m.node.Value()
+}
+
+// Error implements internalIterator.
+func (m *memtableIterator) Error() error {
+	return nil
+}
+
+// Close implements internalIterator.
+func (m *memtableIterator) Close() error {
+	return nil
 }
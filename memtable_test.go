@@ -2,6 +2,8 @@ package rindb
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,3 +47,72 @@ func Test_memtable(t *testing.T) {
 		}
 	}
 }
+
+func Test_memtableSize(t *testing.T) {
+	mem := InitMemtable()
+	assert.Zero(t, mem.Size())
+
+	mem.Put(Bytes("key"), Bytes("value"))
+	assert.Equal(t, 8, mem.Size())
+
+	mem.Clear()
+	assert.Zero(t, mem.Size())
+}
+
+func Test_memtableDelete(t *testing.T) {
+	mem := InitMemtable()
+	mem.Put(Bytes("key"), Bytes("value"))
+	mem.Delete(Bytes("key"))
+
+	got, err := mem.Get(Bytes("key"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Nil(t, got)
+
+	record, err := mem.lookup(Bytes("key"), MaxSequence)
+	assert.NoError(t, err)
+	assert.True(t, IsTombstone(record))
+	assert.Equal(t, uint(2), mem.data.Len())
+}
+
+func Test_memtableVersions(t *testing.T) {
+	mem := InitMemtable()
+	mem.PutRecord(RecordImpl{Key: Bytes("key"), Value: Bytes("v2"), Seq: 2})
+	mem.PutRecord(RecordImpl{Key: Bytes("key"), Value: Bytes("v1"), Seq: 1})
+	assert.Equal(t, uint64(2), mem.LastSeq())
+	assert.Equal(t, uint(2), mem.data.Len())
+
+	got, err := mem.Get(Bytes("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, Bytes("v2"), got)
+
+	mem.Put(Bytes("key"), Bytes("v3"))
+	record, err := mem.lookup(Bytes("key"), MaxSequence)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), record.GetSeq())
+	assert.Equal(t, Bytes("v3"), record.GetValue())
+
+	mem.Clear()
+	assert.Equal(t, uint64(3), mem.LastSeq())
+}
+
+func TestMemtable_concurrentPuts(t *testing.T) {
+	memtable := InitMemtable()
+	var seq atomic.Uint64
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				memtable.PutRecord(RecordImpl{Key: Bytes("key"), Value: Bytes("value"), Seq: seq.Add(1)})
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint(800), memtable.data.Len())
+	assert.Equal(t, uint64(800), memtable.LastSeq())
+	record, err := memtable.lookup(Bytes("key"), MaxSequence)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(800), record.GetSeq())
+}
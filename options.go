@@ -0,0 +1,166 @@
+package rindb
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	defaultMemtableSize        = 4 << 20
+	defaultLevelFileThreshold  = 2
+	defaultBaseLevelSize       = 10 << 20
+	defaultLevelSizeMultiplier = 10
+	defaultTargetFileSize      = 2 << 20
+	defaultGroupCommitMaxSize  = 1 << 20
+)
+
+// Options holds the configuration of a database opened by Open.
+// Zero fields fall back to their defaults.
+type Options struct {
+	// MemtableSize is the approximate number of bytes the memtable
+	// may hold before it is flushed to a level 0 sstable.
+	MemtableSize int
+
+	// LevelFileThreshold is the number of sstables level 0 holds before
+	// it is compacted into the next level.
+	LevelFileThreshold int
+
+	// BaseLevelSize is the number of bytes level 1 holds before it is
+	// compacted into the next level, every deeper level holds
+	// LevelSizeMultiplier times more bytes than the previous one.
+	BaseLevelSize       int64
+	LevelSizeMultiplier int
+
+	// TargetFileSize is the approximate number of bytes of the records
+	// of the sstables written by compactions, their output is split
+	// into files of that size.
+	TargetFileSize int
+
+	// CompactionPicker chooses the files merged by the compactions,
+	// LeveledCompaction is used when it is nil.
+	CompactionPicker CompactionPicker
+
+	// DisableAutoCompaction stops compacting the levels in the background,
+	// they are only compacted by Hino.Compact.
+	DisableAutoCompaction bool
+
+	// BlockSize is the approximate size of the data blocks of the sstables,
+	// it is their unit of reading.
+	BlockSize int
+
+	// BloomFalsePositive is the false positive rate of the bloom filter
+	// of every sstable, checked before reading the sstable for a key.
+	// A negative rate disables the filters.
+	BloomFalsePositive float64
+
+	// Compression is the codec of the data blocks of the sstables written
+	// to each level, the last one applies to the deeper levels. Nothing
+	// is compressed when it is empty.
+	Compression []CompressionType
+
+	// NoSync skips the fsync of the WAL after the writes done without
+	// WriteOptions. Faster, but the latest writes can be lost on a machine
+	// crash.
+	NoSync bool
+
+	// ManualWALFlush keeps the WAL entries of the writes done without
+	// WriteOptions.Sync in memory until DB.FlushWAL, DB.SyncWAL or a sync
+	// write, so they are lost by a crash of the process as well.
+	ManualWALFlush bool
+
+	// WALArchiveDir receives the WAL segments whose memtable is flushed
+	// instead of removing them, they are kept there for WALArchiveTTL.
+	WALArchiveDir string
+
+	// WALArchiveTTL is how long the archived WAL segments are kept,
+	// zero keeps them until they are removed by hand.
+	WALArchiveTTL time.Duration
+
+	// GroupCommitMaxSize is the approximate number of bytes of the
+	// concurrent writes logged together with a single WAL write and sync.
+	GroupCommitMaxSize int
+
+	// GroupCommitDelay is how long the first write of a group waits for
+	// more writes to join it, zero logs the group right away.
+	GroupCommitDelay time.Duration
+
+	// PrefixExtractor gives the prefix of the keys scanned with
+	// IterOptions.PrefixSameAsStart, nil disables it.
+	PrefixExtractor PrefixExtractor
+
+	// WALRecoveryMode tells how corrupted WAL entries are handled on open,
+	// the tail left by a crash is dropped by default.
+	WALRecoveryMode WALRecoveryMode
+
+	// Logger receives the diagnostic messages of the database,
+	// log.Default() is used when it is nil.
+	Logger Logger
+}
+
+// DefaultOptions returns the options used when Open receives nil.
+func DefaultOptions() *Options {
+	return &Options{
+		MemtableSize:        defaultMemtableSize,
+		LevelFileThreshold:  defaultLevelFileThreshold,
+		BaseLevelSize:       defaultBaseLevelSize,
+		LevelSizeMultiplier: defaultLevelSizeMultiplier,
+		TargetFileSize:      defaultTargetFileSize,
+		GroupCommitMaxSize:  defaultGroupCommitMaxSize,
+		CompactionPicker:    LeveledCompaction(),
+		BlockSize:           defaultBlockSize,
+		BloomFalsePositive:  defaultBloomFalsePositive,
+		Logger:              log.Default(),
+	}
+}
+
+// compressionOf returns the codec of the sstables written to a level
+func (o *Options) compressionOf(levelNumb int) CompressionType {
+	if len(o.Compression) == 0 {
+		return NoCompression
+	}
+	if levelNumb >= len(o.Compression) {
+		levelNumb = len(o.Compression) - 1
+	}
+	return o.Compression[levelNumb]
+}
+
+// withDefaults returns a copy of the options with empty fields filled up
+func (o *Options) withDefaults() *Options {
+	defaults := DefaultOptions()
+	if o == nil {
+		return defaults
+	}
+
+	opts := *o
+	if opts.MemtableSize <= 0 {
+		opts.MemtableSize = defaults.MemtableSize
+	}
+	if opts.LevelFileThreshold <= 0 {
+		opts.LevelFileThreshold = defaults.LevelFileThreshold
+	}
+	if opts.BaseLevelSize <= 0 {
+		opts.BaseLevelSize = defaults.BaseLevelSize
+	}
+	if opts.LevelSizeMultiplier <= 1 {
+		opts.LevelSizeMultiplier = defaults.LevelSizeMultiplier
+	}
+	if opts.TargetFileSize <= 0 {
+		opts.TargetFileSize = defaults.TargetFileSize
+	}
+	if opts.GroupCommitMaxSize <= 0 {
+		opts.GroupCommitMaxSize = defaults.GroupCommitMaxSize
+	}
+	if opts.CompactionPicker == nil {
+		opts.CompactionPicker = defaults.CompactionPicker
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = defaults.BlockSize
+	}
+	if opts.BloomFalsePositive == 0 {
+		opts.BloomFalsePositive = defaults.BloomFalsePositive
+	}
+	if opts.Logger == nil {
+		opts.Logger = defaults.Logger
+	}
+	return &opts
+}
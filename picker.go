@@ -0,0 +1,371 @@
+package rindb
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+)
+
+// CompactionPicker chooses the files merged by the compactions of Hino,
+// it is called with the levels locked
+type CompactionPicker interface {
+	// PickCompaction returns nil when the levels don't need a compaction
+	PickCompaction(v LevelsView) (*Compaction, error)
+}
+
+var ErrInvalidCompaction = errors.New("invalid compaction")
+
+// Compaction is the choice of a CompactionPicker: Inputs, files of Level,
+// are merged into new files of OutputLevel. When OutputLevel is deeper
+// than Level, the files of OutputLevel overlapping the inputs are merged
+// as well.
+type Compaction struct {
+	Level       int
+	OutputLevel int
+	Inputs      []FileInfo
+
+	// DropTombstones drops the tombstones visible to every snapshot, it
+	// must only be set when no older version of their keys is left out of
+	// the compaction, see LevelsView.IsBottomLevel
+	DropTombstones bool
+
+	// DeleteOnly removes the inputs without writing their records
+	DeleteOnly bool
+}
+
+// FileInfo describes a sstable of a level
+type FileInfo struct {
+	Name              string
+	Level             int
+	Smallest, Largest Bytes
+	Size              int64
+
+	// LargestSeq is the greatest sequence number stored in the file,
+	// zero when unknown
+	LargestSeq uint64
+
+	// Created is the creation time of the file, zero when unknown
+	Created time.Time
+}
+
+// LevelsView is a read-only view of the levels given to a CompactionPicker
+type LevelsView struct {
+	h *Hino
+}
+
+// Options returns the options of the database
+func (v LevelsView) Options() Options {
+	return *v.h.opts
+}
+
+// NumLevels returns the number of levels, some of them may be empty
+func (v LevelsView) NumLevels() int {
+	return len(v.h.levels)
+}
+
+// Files returns the files of a level, the ones of level 0 from the
+// oldest, the ones of the deeper levels ordered by key range
+func (v LevelsView) Files(levelNumb int) ([]FileInfo, error) {
+	if levelNumb >= len(v.h.levels) || v.h.levels[levelNumb] == nil {
+		return nil, nil
+	}
+	return v.h.fileInfos(levelNumb, v.h.levels[levelNumb].Values())
+}
+
+// LevelSize returns the number of bytes of the files of a level
+func (v LevelsView) LevelSize(levelNumb int) int64 {
+	if levelNumb >= len(v.h.levels) || v.h.levels[levelNumb] == nil {
+		return 0
+	}
+	return v.h.levelSize(levelNumb)
+}
+
+// LevelScore tells how urgent the leveled compaction of a level is,
+// it needs one from 1
+func (v LevelsView) LevelScore(levelNumb int) float64 {
+	if levelNumb >= len(v.h.levels) {
+		return 0
+	}
+	return v.h.levelScore(levelNumb)
+}
+
+// Overlapping returns the files of a level whose key range
+// overlaps [smallest, largest]
+func (v LevelsView) Overlapping(levelNumb int, smallest, largest Bytes) ([]FileInfo, error) {
+	files, err := v.h.overlappingFiles(levelNumb, smallest, largest)
+	if err != nil {
+		return nil, err
+	}
+	return v.h.fileInfos(levelNumb, files)
+}
+
+// IsBottomLevel reports whether no file of levelNumb or deeper
+// overlaps [smallest, largest]
+func (v LevelsView) IsBottomLevel(levelNumb int, smallest, largest Bytes) (bool, error) {
+	return v.h.isBottomLevel(levelNumb, smallest, largest)
+}
+
+// CompactPointer returns the largest key of the last compaction of a
+// level from 1, nil when the level was not compacted yet
+func (v LevelsView) CompactPointer(levelNumb int) Bytes {
+	return v.h.compactPointers[levelNumb]
+}
+
+// KeyRange returns the smallest and the largest keys of files
+func KeyRange(files []FileInfo) (Bytes, Bytes) {
+	var smallest, largest Bytes
+	for _, file := range files {
+		if smallest == nil || Compare(file.Smallest, smallest) == CmpLess {
+			smallest = file.Smallest
+		}
+		if largest == nil || Compare(file.Largest, largest) == CmpGreater {
+			largest = file.Largest
+		}
+	}
+	return smallest, largest
+}
+
+// periodicPicker is a picker whose compactions depend on time, the
+// background compactor runs it every pickInterval
+type periodicPicker interface {
+	pickInterval() time.Duration
+}
+
+// LeveledCompaction keeps the files of the levels from 1 non-overlapping,
+// every level holding LevelSizeMultiplier times more bytes than the
+// previous one. It is the default picker.
+func LeveledCompaction() CompactionPicker {
+	return leveledPicker{}
+}
+
+type leveledPicker struct{}
+
+// PickCompaction returns the compaction of the level with the greatest
+// score, nil when no level needs one. Level 0 files overlap, they are
+// merged as a whole. One file of a deeper level is merged at a time,
+// taken after the last compacted key of its level.
+func (leveledPicker) PickCompaction(v LevelsView) (*Compaction, error) {
+	levelNumb, bestScore := -1, 1.0
+	for idx := 0; idx < v.NumLevels(); idx++ {
+		if score := v.LevelScore(idx); score >= bestScore {
+			if levelNumb == -1 || score > bestScore {
+				levelNumb, bestScore = idx, score
+			}
+		}
+	}
+	if levelNumb == -1 {
+		return nil, nil
+	}
+
+	files, err := v.Files(levelNumb)
+	if err != nil {
+		return nil, err
+	}
+	if levelNumb > 0 {
+		files = []FileInfo{nextFileToCompact(v.CompactPointer(levelNumb), files)}
+	}
+
+	smallest, largest := KeyRange(files)
+	bottom, err := v.IsBottomLevel(levelNumb+2, smallest, largest)
+	if err != nil {
+		return nil, err
+	}
+	return &Compaction{Level: levelNumb, OutputLevel: levelNumb + 1, Inputs: files, DropTombstones: bottom}, nil
+}
+
+// nextFileToCompact returns the first file of a level starting after
+// the last compacted key, the first file of the level once it's the end
+func nextFileToCompact(pointer Bytes, files []FileInfo) FileInfo {
+	if pointer == nil {
+		return files[0]
+	}
+
+	for _, file := range files {
+		if Compare(file.Smallest, pointer) == CmpGreater {
+			return file
+		}
+	}
+	return files[0]
+}
+
+// UniversalOptions tunes UniversalCompaction, zero fields fall back
+// to their defaults
+type UniversalOptions struct {
+	// SizeRatio is the percentage a run may be larger than the newer runs
+	// picked before it to be merged with them
+	SizeRatio int
+
+	// MinMergeWidth is the least number of runs merged at once
+	MinMergeWidth int
+
+	// MaxSizeAmplification is the percentage of the size of the oldest run
+	// the newer runs may reach before every run is merged at once
+	MaxSizeAmplification int
+}
+
+const (
+	defaultUniversalSizeRatio            = 1
+	defaultUniversalMinMergeWidth        = 2
+	defaultUniversalMaxSizeAmplification = 200
+)
+
+// UniversalCompaction is a size-tiered compaction writing less than the
+// leveled one. Every file of level 0 is a sorted run, runs of similar
+// sizes are merged together once level 0 holds LevelFileThreshold runs.
+// The deeper levels are not used.
+func UniversalCompaction(opts UniversalOptions) CompactionPicker {
+	if opts.SizeRatio <= 0 {
+		opts.SizeRatio = defaultUniversalSizeRatio
+	}
+	if opts.MinMergeWidth < 2 {
+		opts.MinMergeWidth = defaultUniversalMinMergeWidth
+	}
+	if opts.MaxSizeAmplification <= 0 {
+		opts.MaxSizeAmplification = defaultUniversalMaxSizeAmplification
+	}
+	return universalPicker{opts}
+}
+
+type universalPicker struct {
+	opts UniversalOptions
+}
+
+// PickCompaction merges every run when the newer runs are too large
+// compared to the oldest one. Otherwise it merges the newest group of
+// runs where each run is not larger than the sum of the newer ones by
+// more than SizeRatio. Without such group the newest runs are merged
+// to get back under the threshold.
+func (p universalPicker) PickCompaction(v LevelsView) (*Compaction, error) {
+	files, err := v.Files(0)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	// runs are ordered from the newest one
+	runs := make([]FileInfo, 0, len(files))
+	for idx := len(files) - 1; idx >= 0; idx-- {
+		runs = append(runs, files[idx])
+	}
+	threshold := v.Options().LevelFileThreshold
+	if len(runs) < threshold || len(runs) < p.opts.MinMergeWidth {
+		return nil, nil
+	}
+
+	newer := int64(0)
+	for _, run := range runs[:len(runs)-1] {
+		newer += run.Size
+	}
+	if newer*100 >= runs[len(runs)-1].Size*int64(p.opts.MaxSizeAmplification) {
+		return p.compaction(v, runs, true)
+	}
+
+	for start := range runs {
+		sum, end := runs[start].Size, start+1
+		for end < len(runs) && runs[end].Size*100 <= sum*int64(100+p.opts.SizeRatio) {
+			sum += runs[end].Size
+			end++
+		}
+		if end-start >= p.opts.MinMergeWidth {
+			return p.compaction(v, runs[start:end], end == len(runs))
+		}
+	}
+
+	width := min(len(runs), max(p.opts.MinMergeWidth, len(runs)-threshold+1))
+	return p.compaction(v, runs[:width], width == len(runs))
+}
+
+// compaction merges runs into a run of level 0, tombstones are dropped
+// when the oldest run is merged and no deeper level overlaps
+func (p universalPicker) compaction(v LevelsView, runs []FileInfo, oldest bool) (*Compaction, error) {
+	// inputs are ordered from the oldest one like the level
+	inputs := make([]FileInfo, 0, len(runs))
+	for idx := len(runs) - 1; idx >= 0; idx-- {
+		inputs = append(inputs, runs[idx])
+	}
+
+	c := &Compaction{Level: 0, OutputLevel: 0, Inputs: inputs}
+	if oldest {
+		smallest, largest := KeyRange(inputs)
+		bottom, err := v.IsBottomLevel(1, smallest, largest)
+		if err != nil {
+			return nil, err
+		}
+		c.DropTombstones = bottom
+	}
+	return c, nil
+}
+
+// FIFOOptions tunes FIFOCompaction, a zero limit is disabled
+type FIFOOptions struct {
+	// MaxTableFilesSize is the number of bytes level 0 holds before its
+	// oldest files are deleted
+	MaxTableFilesSize int64
+
+	// TTL is the age from which the files of level 0 are deleted
+	TTL time.Duration
+}
+
+// FIFOCompaction never merges files, it deletes the oldest files of
+// level 0 once they are too old or level 0 is too large. It suits
+// databases used as a cache of recent data, the deeper levels are not
+// used.
+func FIFOCompaction(opts FIFOOptions) CompactionPicker {
+	return fifoPicker{opts: opts, now: time.Now}
+}
+
+type fifoPicker struct {
+	opts FIFOOptions
+
+	// now gives the current time to age the files
+	now func() time.Time
+}
+
+func (p fifoPicker) PickCompaction(v LevelsView) (*Compaction, error) {
+	files, err := v.Files(0)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	size := v.LevelSize(0)
+	expired := 0
+	for _, file := range files {
+		tooLarge := p.opts.MaxTableFilesSize > 0 && size > p.opts.MaxTableFilesSize
+		tooOld := p.opts.TTL > 0 && !file.Created.IsZero() && p.now().Sub(file.Created) > p.opts.TTL
+		if !tooLarge && !tooOld {
+			break
+		}
+		size -= file.Size
+		expired++
+	}
+	if expired == 0 {
+		return nil, nil
+	}
+	return &Compaction{Level: 0, OutputLevel: 0, Inputs: files[:expired], DeleteOnly: true}, nil
+}
+
+// pickInterval checks the age of the files ten times per TTL
+func (p fifoPicker) pickInterval() time.Duration {
+	if p.opts.TTL <= 0 {
+		return time.Hour
+	}
+	return max(p.opts.TTL/10, time.Second)
+}
+
+// fileTime returns the creation time of a sstable given by the ulid
+// of its name, zero when it is unknown
+func fileTime(fs *FileSystem) time.Time {
+	name := strings.TrimSuffix(path.Base(fs.Path()), sstableSuffix)
+	idx := strings.Index(name, "_")
+	if idx == -1 {
+		return time.Time{}
+	}
+
+	uid, err := ulid.Parse(name[idx+1:])
+	if err != nil {
+		return time.Time{}
+	}
+	return ulid.Time(uid.Time())
+}
@@ -0,0 +1,67 @@
+package rindb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"rindb"
+)
+
+// mergeAllPicker merges level 0 into level 1 once it holds two files,
+// it remembers the files of level 1 seen by its last call
+type mergeAllPicker struct {
+	deeper []rindb.FileInfo
+}
+
+func (p *mergeAllPicker) PickCompaction(v rindb.LevelsView) (*rindb.Compaction, error) {
+	files, err := v.Files(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.deeper, err = v.Files(1); err != nil || len(files) < 2 {
+		return nil, err
+	}
+
+	smallest, largest := rindb.KeyRange(files)
+	bottom, err := v.IsBottomLevel(2, smallest, largest)
+	if err != nil {
+		return nil, err
+	}
+	return &rindb.Compaction{Level: 0, OutputLevel: 1, Inputs: files, DropTombstones: bottom}, nil
+}
+
+func TestCompactionPicker_custom(t *testing.T) {
+	dir := t.TempDir()
+	picker := &mergeAllPicker{}
+	opts := &rindb.Options{
+		MemtableSize:          64,
+		NoSync:                true,
+		DisableAutoCompaction: true,
+		CompactionPicker:      picker,
+	}
+	hino, err := rindb.InitHino(dir, opts)
+	assert.NoError(t, err)
+	defer hino.Close()
+
+	rin, err := rindb.InitRinDB(dir, hino, opts)
+	assert.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, rin.Put(rindb.Bytes(fmt.Sprintf("key%02d", i)), rindb.Bytes(fmt.Sprint(i))))
+	}
+	assert.NoError(t, rin.Close())
+
+	assert.NoError(t, hino.Compact())
+	assert.Len(t, picker.deeper, 1)
+	assert.Equal(t, rindb.Bytes("key00"), picker.deeper[0].Smallest)
+
+	rin, err = rindb.InitRinDB(dir, hino, opts)
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, rin.Close()) }()
+	for i := 0; i < 50; i++ {
+		value, err := rin.Get(rindb.Bytes(fmt.Sprintf("key%02d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, rindb.Bytes(fmt.Sprint(i)), value)
+	}
+}
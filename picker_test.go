@@ -0,0 +1,263 @@
+package rindb
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// commitRun commits a level 0 file of count keys from start
+func commitRun(t *testing.T, h *Hino, start, count int) {
+	keys := make([]string, 0, count)
+	for i := start; i < start+count; i++ {
+		keys = append(keys, fmt.Sprintf("key%04d", i))
+	}
+	commitTable(t, h, 0, keys...)
+}
+
+func TestUniversalCompaction(t *testing.T) {
+	t.Run("runs of similar sizes are merged", func(t *testing.T) {
+		h := newHino(t.TempDir(), &Options{
+			LevelFileThreshold: 4,
+			CompactionPicker:   UniversalCompaction(UniversalOptions{}),
+		})
+		defer h.Close()
+
+		commitRun(t, h, 0, 200)
+		for i := 0; i < 2; i++ {
+			commitRun(t, h, 1000+i*10, 10)
+		}
+		c, err := h.pickCompaction()
+		assert.NoError(t, err)
+		assert.Nil(t, c)
+
+		commitRun(t, h, 1020, 10)
+		files := h.levels[0].Values()
+		c, err = h.pickCompaction()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, c.outputLevel)
+		assert.False(t, c.dropTombstones)
+		assert.Len(t, c.inputs, 3)
+		for idx, sstable := range c.inputs {
+			assert.Equal(t, files[idx+1].Path(), sstable.Path())
+		}
+
+		assert.NoError(t, h.runCompaction(c))
+		assert.Equal(t, 2, h.levels[0].Len())
+		assert.Equal(t, files[0], h.levels[0].Values()[0])
+		assert.Len(t, h.levels, 1)
+	})
+
+	t.Run("every run is merged when the newer ones are too large", func(t *testing.T) {
+		h := newHino(t.TempDir(), &Options{
+			LevelFileThreshold: 3,
+			CompactionPicker:   UniversalCompaction(UniversalOptions{MaxSizeAmplification: 100}),
+		})
+		defer h.Close()
+
+		commitRun(t, h, 0, 10)
+		commitRun(t, h, 100, 100)
+		commitRun(t, h, 300, 200)
+
+		c, err := h.pickCompaction()
+		assert.NoError(t, err)
+		assert.Len(t, c.inputs, 3)
+		assert.True(t, c.dropTombstones)
+	})
+
+	t.Run("newest runs are merged to get under the threshold", func(t *testing.T) {
+		h := newHino(t.TempDir(), &Options{
+			LevelFileThreshold: 2,
+			CompactionPicker:   UniversalCompaction(UniversalOptions{}),
+		})
+		defer h.Close()
+
+		commitRun(t, h, 0, 400)
+		commitRun(t, h, 1000, 100)
+		commitRun(t, h, 2000, 10)
+
+		c, err := h.pickCompaction()
+		assert.NoError(t, err)
+		assert.Len(t, c.inputs, 2)
+		assert.False(t, c.dropTombstones)
+	})
+}
+
+func TestDB_universalCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		MemtableSize:          512,
+		LevelFileThreshold:    4,
+		NoSync:                true,
+		DisableAutoCompaction: true,
+		CompactionPicker:      UniversalCompaction(UniversalOptions{}),
+	}
+	db, err := Open(dir, opts)
+	assert.NoError(t, err)
+
+	random := rand.New(rand.NewSource(1))
+	model := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%04d", random.Intn(500))
+		if random.Intn(5) == 0 {
+			assert.NoError(t, db.Remove(Bytes(key)))
+			delete(model, key)
+		} else {
+			value := fmt.Sprintf("value%d", i)
+			assert.NoError(t, db.Put(Bytes(key), Bytes(value)))
+			model[key] = value
+		}
+
+		if i%300 == 299 {
+			db.rin.flushes.Wait()
+			assert.NoError(t, db.hino.Compact())
+			assert.Less(t, db.hino.levels[0].Len(), opts.LevelFileThreshold)
+		}
+	}
+	db.rin.flushes.Wait()
+	assert.NoError(t, db.hino.Compact())
+	assert.Len(t, db.hino.levels, 1)
+	assert.NoError(t, db.Close())
+
+	// level 0 keeps its order on reopen
+	db, err = Open(dir, opts)
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		value, err := db.Get(Bytes(key))
+		if expected, ok := model[key]; ok {
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes(expected), value)
+		} else {
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+		}
+	}
+}
+
+func TestFIFOCompaction(t *testing.T) {
+	t.Run("oldest files are deleted over the size limit", func(t *testing.T) {
+		h := newHino(t.TempDir(), &Options{LevelFileThreshold: 1})
+		defer h.Close()
+
+		for i := 0; i < 5; i++ {
+			commitRun(t, h, i*10, 10)
+		}
+		fileSize := h.levelSize(0) / 5
+		h.opts.CompactionPicker = FIFOCompaction(FIFOOptions{MaxTableFilesSize: 3 * fileSize})
+		files := h.levels[0].Values()
+
+		assert.NoError(t, h.Compact())
+		assert.Equal(t, files[2:], h.levels[0].Values())
+		assert.LessOrEqual(t, h.levelSize(0), 3*fileSize)
+		assert.Len(t, h.levels, 1)
+		for _, fs := range files[:2] {
+			assert.NoFileExists(t, fs.Path())
+		}
+
+		_, err := h.searchKey(Bytes("key0000"), MaxSequence, true)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		record, err := h.searchKey(Bytes("key0020"), MaxSequence, true)
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("key0020"), record.GetValue())
+	})
+
+	t.Run("files older than the TTL are deleted", func(t *testing.T) {
+		h := newHino(t.TempDir(), nil)
+		defer h.Close()
+
+		// file times have a millisecond precision
+		for i := 0; i < 4; i++ {
+			commitRun(t, h, i*10, 10)
+			time.Sleep(2 * time.Millisecond)
+		}
+		files := h.levels[0].Values()
+		picker := FIFOCompaction(FIFOOptions{TTL: time.Hour}).(fifoPicker)
+		picker.now = func() time.Time { return fileTime(files[1]).Add(time.Hour + time.Millisecond) }
+		h.opts.CompactionPicker = picker
+
+		assert.NoError(t, h.Compact())
+		assert.Equal(t, files[2:], h.levels[0].Values())
+
+		assert.True(t, fileTime(&FileSystem{filePath: "l00_orphan.sst"}).IsZero())
+	})
+
+	t.Run("a database used as a cache keeps its recent data", func(t *testing.T) {
+		limit := int64(8 << 10)
+		db, err := Open(t.TempDir(), &Options{
+			MemtableSize:     1 << 10,
+			NoSync:           true,
+			CompactionPicker: FIFOCompaction(FIFOOptions{MaxTableFilesSize: limit}),
+		})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		for i := 0; i < 2000; i++ {
+			assert.NoError(t, db.Put(Bytes(fmt.Sprintf("event%05d", i)), Bytes("payload")))
+		}
+		db.rin.flushes.Wait()
+		assert.NoError(t, db.hino.Compact())
+
+		db.hino.mu.Lock()
+		assert.LessOrEqual(t, db.hino.levelSize(0), limit)
+		assert.Len(t, db.hino.levels, 1)
+		db.hino.mu.Unlock()
+		_, err = db.Get(Bytes("event00000"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		value, err := db.Get(Bytes("event01999"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("payload"), value)
+	})
+}
+
+// pickerFunc turns a function into a CompactionPicker
+type pickerFunc func(v LevelsView) (*Compaction, error)
+
+func (f pickerFunc) PickCompaction(v LevelsView) (*Compaction, error) {
+	return f(v)
+}
+
+func TestHino_newCompaction(t *testing.T) {
+	var picked *Compaction
+	h := newHino(t.TempDir(), &Options{
+		CompactionPicker: pickerFunc(func(LevelsView) (*Compaction, error) { return picked, nil }),
+	})
+	defer h.Close()
+
+	commitTable(t, h, 0, "a", "c")
+	commitTable(t, h, 0, "b", "d")
+	commitTable(t, h, 1, "a", "b")
+	commitTable(t, h, 1, "c", "d")
+	commitTable(t, h, 1, "e", "f")
+	files, err := LevelsView{h}.Files(0)
+	assert.NoError(t, err)
+	deeper, err := LevelsView{h}.Files(1)
+	assert.NoError(t, err)
+
+	for _, invalid := range []*Compaction{
+		{Level: 0, OutputLevel: 1},
+		{Level: 1, OutputLevel: 0, Inputs: deeper[:1]},
+		{Level: 3, OutputLevel: 3, Inputs: files},
+		{Level: 0, OutputLevel: 1, Inputs: []FileInfo{{Name: "l00_missing.sst"}}},
+		// the outputs would overlap the file left in between
+		{Level: 1, OutputLevel: 1, Inputs: []FileInfo{deeper[0], deeper[2]}},
+	} {
+		picked = invalid
+		_, err := h.pickCompaction()
+		assert.ErrorIs(t, err, ErrInvalidCompaction)
+	}
+
+	// inputs are merged in the order of their level
+	picked = &Compaction{Level: 0, OutputLevel: 1, Inputs: []FileInfo{files[1], files[0]}}
+	c, err := h.pickCompaction()
+	assert.NoError(t, err)
+	assert.Equal(t, files[0].Name, path.Base(c.inputs[0].Path()))
+	assert.Len(t, c.overlaps, 2)
+	assert.Len(t, c.inputs, 2)
+	assert.Equal(t, Bytes("a"), c.smallest)
+	assert.Equal(t, Bytes("d"), c.largest)
+}
@@ -0,0 +1,100 @@
+package rindb
+
+import "bytes"
+
+// PrefixExtractor returns the prefix of a key, keys sharing a prefix are
+// scanned together with IterOptions.PrefixSameAsStart. Prefix returns nil
+// when the key has no prefix.
+type PrefixExtractor interface {
+	Prefix(key Bytes) Bytes
+}
+
+type fixedPrefix int
+
+// FixedPrefix extracts the first n bytes of the keys,
+// shorter keys have no prefix
+func FixedPrefix(n int) PrefixExtractor {
+	return fixedPrefix(n)
+}
+
+// Prefix implements PrefixExtractor.
+func (n fixedPrefix) Prefix(key Bytes) Bytes {
+	if len(key) < int(n) {
+		return nil
+	}
+	return key[:n]
+}
+
+type separatorPrefix struct {
+	separator byte
+	count     int
+}
+
+// SeparatorPrefix extracts the beginning of the keys up to their count-th
+// separator included, SeparatorPrefix('/', 2) gives "tenant/entity/" for
+// "tenant/entity/id". Keys with fewer separators have no prefix.
+func SeparatorPrefix(separator byte, count int) PrefixExtractor {
+	return separatorPrefix{separator: separator, count: count}
+}
+
+// Prefix implements PrefixExtractor.
+func (s separatorPrefix) Prefix(key Bytes) Bytes {
+	end := 0
+	for found := 0; found < s.count; found++ {
+		idx := bytes.IndexByte(key[end:], s.separator)
+		if idx < 0 {
+			return nil
+		}
+		end += idx + 1
+	}
+	return key[:end]
+}
+
+// prefixSuccessor returns the smallest key greater than every key starting
+// with prefix, nil when there is no such key
+func prefixSuccessor(prefix Bytes) Bytes {
+	for idx := len(prefix) - 1; idx >= 0; idx-- {
+		if prefix[idx] != 0xff {
+			successor := append(Bytes(nil), prefix[:idx+1]...)
+			successor[idx]++
+			return successor
+		}
+	}
+	return nil
+}
+
+// iterBounds is the key range [lower, upper) of an iteration,
+// nil bounds are open
+type iterBounds struct {
+	lower, upper Bytes
+}
+
+// narrow returns the intersection of the bounds with [lower, upper)
+func (b iterBounds) narrow(lower, upper Bytes) iterBounds {
+	if lower != nil && (b.lower == nil || Compare(lower, b.lower) == CmpGreater) {
+		b.lower = lower
+	}
+	if upper != nil && (b.upper == nil || Compare(upper, b.upper) == CmpLess) {
+		b.upper = upper
+	}
+	return b
+}
+
+// withPrefix narrows the bounds to the keys starting with prefix
+func (b iterBounds) withPrefix(prefix Bytes) iterBounds {
+	if prefix == nil {
+		return b
+	}
+	return b.narrow(prefix, prefixSuccessor(prefix))
+}
+
+// overlaps reports whether [smallest, largest] intersects the bounds
+func (b iterBounds) overlaps(smallest, largest Bytes) bool {
+	if b.lower != nil && Compare(largest, b.lower) == CmpLess {
+		return false
+	}
+	if b.upper != nil && Compare(smallest, b.upper) != CmpLess {
+		return false
+	}
+	return true
+}
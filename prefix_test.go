@@ -0,0 +1,131 @@
+package rindb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixExtractor(t *testing.T) {
+	assert.Equal(t, Bytes("ten"), FixedPrefix(3).Prefix(Bytes("tenant")))
+	assert.Nil(t, FixedPrefix(3).Prefix(Bytes("te")))
+
+	extractor := SeparatorPrefix('/', 2)
+	assert.Equal(t, Bytes("tenant/entity/"), extractor.Prefix(Bytes("tenant/entity/id")))
+	assert.Equal(t, Bytes("tenant/entity/"), extractor.Prefix(Bytes("tenant/entity/")))
+	assert.Nil(t, extractor.Prefix(Bytes("tenant/entity")))
+}
+
+func Test_prefixSuccessor(t *testing.T) {
+	assert.Equal(t, Bytes("ab"), prefixSuccessor(Bytes("aa")))
+	assert.Equal(t, Bytes("b"), prefixSuccessor(Bytes{'a', 0xff}))
+	assert.Nil(t, prefixSuccessor(Bytes{0xff, 0xff}))
+	assert.Nil(t, prefixSuccessor(nil))
+}
+
+// tenantDB fills a database with keys of 3 tenants, each one flushed
+// to its own sstables
+func tenantDB(t *testing.T) *DB {
+	db, err := Open(t.TempDir(), &Options{
+		MemtableSize:          100,
+		NoSync:                true,
+		PrefixExtractor:       SeparatorPrefix('/', 2),
+		DisableAutoCompaction: true,
+	})
+	assert.NoError(t, err)
+
+	for _, tenant := range []string{"t1", "t2", "t3"} {
+		for _, entity := range []string{"order", "user"} {
+			for id := 0; id < 5; id++ {
+				key := fmt.Sprintf("%s/%s/%d", tenant, entity, id)
+				assert.NoError(t, db.Put(Bytes(key), Bytes(key)))
+			}
+		}
+		db.rin.flushes.Wait()
+	}
+	return db
+}
+
+//nolint:funlen
+func TestDBIterator_prefix(t *testing.T) {
+	t.Run("prefix restricts the iteration", func(t *testing.T) {
+		db := tenantDB(t)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		it := db.NewIterator(IterOptions{Prefix: Bytes("t2/user/")})
+		defer func() { assert.NoError(t, it.Close()) }()
+
+		it.SeekToFirst()
+		assert.Equal(t, []string{
+			"t2/user/0=t2/user/0", "t2/user/1=t2/user/1", "t2/user/2=t2/user/2",
+			"t2/user/3=t2/user/3", "t2/user/4=t2/user/4",
+		}, scan(it, true))
+
+		it.SeekToLast()
+		assert.Equal(t, Bytes("t2/user/4"), it.Key())
+
+		it.Seek(Bytes("t1"))
+		assert.Equal(t, Bytes("t2/user/0"), it.Key())
+		it.Seek(Bytes("t3"))
+		assert.False(t, it.Valid())
+		assert.NoError(t, it.Error())
+	})
+
+	t.Run("sstables out of the prefix are not opened", func(t *testing.T) {
+		db := tenantDB(t)
+		defer func() { assert.NoError(t, db.Close()) }()
+		assert.Greater(t, db.hino.levels[0].Len(), 2)
+
+		it := db.NewIterator(IterOptions{Prefix: Bytes("t3/")})
+		defer func() { assert.NoError(t, it.Close()) }()
+
+		tables := 0
+		for _, child := range it.iter.children {
+			if table, ok := child.(*tableIterator); ok {
+				tables++
+				assert.True(t, it.bounds.overlaps(table.smallest, table.largest))
+			}
+		}
+		assert.Less(t, tables, db.hino.levels[0].Len())
+
+		it.SeekToFirst()
+		assert.Len(t, scan(it, true), 10)
+	})
+
+	t.Run("prefix same as start follows the extractor", func(t *testing.T) {
+		db := tenantDB(t)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		it := db.NewIterator(IterOptions{PrefixSameAsStart: true})
+		defer func() { assert.NoError(t, it.Close()) }()
+
+		it.Seek(Bytes("t1/user/3"))
+		assert.Equal(t, []string{"t1/user/3=t1/user/3", "t1/user/4=t1/user/4"}, scan(it, true))
+
+		// the sstables of the other tenants are not opened
+		tables, opened := 0, 0
+		for _, child := range it.iter.children {
+			if table, ok := child.(*tableIterator); ok {
+				tables++
+				if table.file != nil {
+					opened++
+					assert.Equal(t, CmpLess, Compare(table.smallest, Bytes("t2")))
+				}
+			}
+		}
+		assert.Equal(t, db.hino.levels[0].Len(), tables)
+		assert.Positive(t, opened)
+		assert.Less(t, opened, tables)
+
+		it.Seek(Bytes("t2/order/"))
+		assert.Len(t, scan(it, true), 5)
+
+		// keys without prefix are not restricted
+		it.Seek(Bytes("t3"))
+		assert.Len(t, scan(it, true), 10)
+
+		it.SeekToFirst()
+		assert.Len(t, scan(it, true), 30)
+	})
+}
@@ -1,21 +1,46 @@
 package rindb
 
+// RecordType tags a record stored in the WAL or a sstable
+type RecordType uint8
+
+const (
+	// RecordTypePut is a key holding a value
+	RecordTypePut RecordType = iota
+	// RecordTypeDelete is a tombstone, it shadows older versions of the key
+	RecordTypeDelete
+)
+
+const recordTypeSize = 1
+
 type Record interface {
 	GetKey() Bytes
 	GetValue() Bytes
+	GetType() RecordType
+	GetSeq() uint64
 	GetSize() int
 }
 
 func CalOnDiskSize(r Record) int {
-	return (mdByteSize /* key len size */ +
+	return (recordTypeSize /* record type size */ +
+		mdByteSize /* sequence number size */ +
+		mdByteSize /* key len size */ +
 		mdByteSize /* value len size */ +
 		r.GetSize() /* all key&value size */)
 }
 
+// IsTombstone reports whether the record deletes its key
+func IsTombstone(r Record) bool {
+	return r.GetType() == RecordTypeDelete
+}
+
 var _ Record = RecordImpl{}
 
 type RecordImpl struct {
 	Key, Value Bytes
+	Type       RecordType
+
+	// Seq orders the versions of a key, newer writes get greater numbers
+	Seq uint64
 }
 
 // GetKey implements Record.
@@ -28,6 +53,16 @@ func (r RecordImpl) GetValue() Bytes {
 	return r.Value
 }
 
+// GetType implements Record.
+func (r RecordImpl) GetType() RecordType {
+	return r.Type
+}
+
+// GetSeq implements Record.
+func (r RecordImpl) GetSeq() uint64 {
+	return r.Seq
+}
+
 // GetSize implements Record.
 func (r RecordImpl) GetSize() int {
 	return len(r.GetKey()) + len(r.GetValue())
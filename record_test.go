@@ -17,13 +17,13 @@ func TestCalOnDiskSize(t *testing.T) {
 	}{
 		{
 			name: "Key and value",
-			args: args{RecordImpl{Bytes("key"), Bytes("value")}},
-			want: 24,
+			args: args{RecordImpl{Key: Bytes("key"), Value: Bytes("value")}},
+			want: 33,
 		},
 		{
 			name: "Empty key and value",
-			args: args{RecordImpl{Bytes(nil), Bytes(nil)}},
-			want: 16,
+			args: args{RecordImpl{Key: Bytes(nil), Value: Bytes(nil)}},
+			want: 25,
 		},
 	}
 	for _, tt := range tests {
@@ -3,46 +3,189 @@ package rindb
 
 import (
 	"container/list"
+	stderrors "errors"
 	"fmt"
-	"log"
+	"math"
 	"os"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
 )
 
 // https://github.com/google/leveldb/blob/main/doc/impl.md
 
-var (
-	dbDirectory = "testdata"
-	walName     = "WAL"
+const (
+	walName = "WAL"
+
+	// frozenWALPrefix prefixes the WALs of immutable memtables written
+	// before the WAL was split into numbered segments, it's followed by
+	// an ulid so they sort by creation time
+	frozenWALPrefix = walName + "_"
 )
 
+// Rin is safe for concurrent use. Reads go on in parallel, writes queue
+// up and are committed by groups in their queue order.
 type Rin struct {
+	dir  string
+	opts *Options
+	log  dbLogger
+
+	// mu guards the WAL, the memtable, immutables, lastSeq and bgErr which
+	// are shared with the reads and the flushes. The leader of the writers
+	// changes them while holding mu, it reads them without.
+	mu       sync.RWMutex
 	wal      WAL
 	memtable Memtable
+
+	// walNumber is the number of the WAL segment in use
+	walNumber uint64
+
+	// writers queues the writes, the front one leads the group committed
+	// next while the others wait on writerTurn
+	writers    *list.List
+	writerTurn *sync.Cond
+
+	// immutables are frozen memtables waiting to be flushed, oldest first
+	immutables []*immutableMemtable
+
+	// bgErr is the error of the last failed background flush
+	bgErr error
+
+	// flushes tracks the running background flushes
+	flushes sync.WaitGroup
+
+	// lastFlush is closed once the latest scheduled flush is over,
+	// every flush waits for the previous one to keep level 0 ordered
+	lastFlush chan struct{}
+
+	// lastSeq is the sequence number of the latest write visible to reads
+	lastSeq uint64
+
+	// unlogged tells whether the memtable holds writes done without the
+	// WAL, Close flushes it then. closed is set by Close. Only the leader
+	// of the writers uses them.
+	unlogged bool
+	closed   bool
+
+	// hino serves the keys which are not in memory anymore, may be nil
+	hino *Hino
+
+	// recoveryReport lists what was dropped from the WALs on init
+	recoveryReport WALRecoveryReport
+}
+
+// immutableMemtable is a frozen memtable and the WAL backing it
+type immutableMemtable struct {
+	Memtable
+	walPath string
 }
 
 type Hino struct {
+	// mu guards levels and tables, levels get new files from background
+	// flushes. Reads hold it for reading so they go on in parallel.
+	mu sync.RWMutex
+
+	// tablesMu guards tables while they are loaded by reads
+	tablesMu sync.Mutex
+
+	dir      string
+	opts     *Options
+	log      dbLogger
 	openedFs *list.List
 	levels   []*LinkedList[*FileSystem]
+
+	// tables caches loaded sstables by their path
+	tables map[string]SStable
+
+	// metas holds the metadata of every file of the levels by its path
+	metas map[string]fileMeta
+
+	// pins counts the iterators which may still open each sstable, the
+	// removal of a pinned sstable is left to its last iterator through
+	// obsolete. pinsMu guards both, iterators are built holding mu for
+	// reading only.
+	pinsMu   sync.Mutex
+	pins     map[string]int
+	obsolete map[string]bool
+
+	// manifest commits the changes of levels, hino without a
+	// manifest keeps its levels in memory only
+	manifest *manifest
+
+	// lastSequence is the greatest sequence number stored in the levels
+	lastSequence uint64
+
+	// liveWALs are the numbers of the WAL segments whose memtable is not
+	// flushed yet, ascending
+	liveWALs []uint64
+
+	// snapshots are the live snapshots whose versions compactions keep
+	snapshots *snapshotList
+
+	// compactionMu serializes the compactions, they don't hold mu while
+	// merging so reads and flushes go on meanwhile
+	compactionMu sync.Mutex
+
+	// compactionRequests wakes the background compactor up
+	compactionRequests chan struct{}
+
+	// closing stops the background compactor, closeOnce
+	// lets Hino.Close run once
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// compactor tracks the background compactor
+	compactor sync.WaitGroup
+
+	// compactPointers hold the largest key of the last compaction of each
+	// level from 1, the next compaction of the level starts after it
+	compactPointers map[int]Bytes
+}
+
+func newHino(dir string, opts *Options) *Hino {
+	opts = opts.withDefaults()
+	return &Hino{
+		dir:       dir,
+		opts:      opts,
+		log:       dbLogger{opts.Logger},
+		openedFs:  list.New(),
+		tables:    make(map[string]SStable),
+		metas:     make(map[string]fileMeta),
+		pins:      make(map[string]int),
+		obsolete:  make(map[string]bool),
+		snapshots: newSnapshotList(),
+
+		compactionRequests: make(chan struct{}, 1),
+		closing:            make(chan struct{}),
+		compactPointers:    make(map[int]Bytes),
+	}
 }
 
-func InitHino() (*Hino, error) {
-	h := &Hino{openedFs: list.New()}
-	err := h.LoadLevels()
+func InitHino(dir string, opts *Options) (*Hino, error) {
+	h := newHino(dir, opts)
+	err := h.recover()
 	if err != nil {
+		h.Close()
 		return nil, err
 	}
 
+	if !h.opts.DisableAutoCompaction {
+		h.startCompactor()
+		h.scheduleCompaction()
+	}
 	return h, nil
 }
 
+// LoadLevels loads the levels from the sstable file names, it's used for
+// directories written before the MANIFEST existed.
 func (h *Hino) LoadLevels() error {
-	dirEntries, err := os.ReadDir(dbDirectory)
+	dirEntries, err := os.ReadDir(h.dir)
 	if err != nil {
 		return err
 	}
@@ -54,19 +197,19 @@ func (h *Hino) LoadLevels() error {
 	levels := make([]*LinkedList[*FileSystem], 0)
 	for _, dirEntry := range dirEntries {
 		fileName := dirEntry.Name()
-		filePath := path.Join(dbDirectory, fileName)
-		isSSTable := strings.HasSuffix(filePath, ".sst")
+		filePath := path.Join(h.dir, fileName)
+		isSSTable := strings.HasSuffix(filePath, sstableSuffix)
 		if !isSSTable {
 			continue
 		}
 
 		idx := strings.Index(fileName, "_")
 		if idx == -1 {
-			// TODO: log here, because this sstable doesn't follow the sstable instruction
+			h.log.WARN("Skipped %s, it doesn't follow the sstable naming", filePath)
 			continue
 		}
 
-		levelNumb, err := strconv.ParseInt(fileName[1:idx], 32, 32)
+		levelNumb, err := strconv.ParseInt(fileName[1:idx], 10, 32)
 		if err != nil {
 			return err
 		}
@@ -85,9 +228,18 @@ func (h *Hino) LoadLevels() error {
 	return nil
 }
 
+// tableOptions returns the layout of the sstables written to a level
+func (h *Hino) tableOptions(levelNumb int) tableOptions {
+	opts := defaultTableOptions()
+	opts.blockSize = h.opts.BlockSize
+	opts.bloomFalsePositive = h.opts.BloomFalsePositive
+	opts.compression = h.opts.compressionOf(levelNumb)
+	return opts
+}
+
 func (h *Hino) NewSSTableFS(levelNumb int) (*FileSystem, error) {
 	uid := ulid.Make()
-	sstableFileName := path.Join(dbDirectory, fmt.Sprintf("l%02d_%s.sst", levelNumb, uid.String()))
+	sstableFileName := path.Join(h.dir, fmt.Sprintf("l%02d_%s%s", levelNumb, uid.String(), sstableSuffix))
 	fs, err := OpenFS(sstableFileName)
 	if err != nil {
 		return nil, err
@@ -95,7 +247,26 @@ func (h *Hino) NewSSTableFS(levelNumb int) (*FileSystem, error) {
 	return fs, nil
 }
 
+// Close stops the compactor and closes the files of hino,
+// calling it again does nothing
 func (h *Hino) Close() {
+	h.closeOnce.Do(h.close)
+}
+
+func (h *Hino) close() {
+	close(h.closing)
+	h.compactor.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.manifest != nil {
+		if err := h.manifest.Close(); err != nil {
+			h.log.ERROR("Error closing manifest %s: %v", h.manifest.Path(), err)
+		}
+		h.manifest = nil
+	}
+
 	element := h.openedFs.Front()
 	for {
 		if element == nil {
@@ -103,37 +274,40 @@ func (h *Hino) Close() {
 		}
 		fs, ok := element.Value.(*FileSystem)
 		if !ok {
-			log.Println("[ERR] can not cast element to file system")
-			// TODO: log information over here
+			h.log.ERROR("Can not cast element %v to file system", element.Value)
 			break
 		}
 
 		if err := fs.Close(); err != nil {
-			ERROR("Error closing file %s: %v", fs.Path(), err)
+			h.log.ERROR("Error closing file %s: %v", fs.Path(), err)
 		}
-		INFO("Closed %s successfully", fs.Path())
+		h.log.INFO("Closed %s successfully", fs.Path())
 		element = element.Next()
 	}
 
 	for _, level := range h.levels {
+		if level == nil {
+			continue
+		}
+
 		levelIterator := level.Iterator()
 		for levelIterator.HasNext() {
 			fs, err := levelIterator.Next()
 			if err != nil {
-				ERROR("Error iterating through level: %v", err)
+				h.log.ERROR("Error iterating through level: %v", err)
 				continue
 			}
 
 			if !fs.IsOpened() {
-				INFO("File %s is already closed", fs.Path())
+				h.log.INFO("File %s is already closed", fs.Path())
 				continue
 			}
 
 			if err := fs.Close(); err != nil {
-				ERROR("Error closing file %s: %v", fs.Path(), err)
+				h.log.ERROR("Error closing file %s: %v", fs.Path(), err)
 				continue
 			}
-			INFO("Closed %s successfully", fs.Path())
+			h.log.INFO("Closed %s successfully", fs.Path())
 		}
 	}
 }
@@ -188,154 +362,966 @@ MANIFEST:
     bloom filter: <bin>
   - level n1: file 1, file 2,  ...  bloom filter: <bin>
 */
-func (h *Hino) Compact() error {
-	levelNumb := 0
-	for {
-		if levelNumb == len(h.levels) {
+
+// commitFlush adds the sstable of a flushed memtable to level 0,
+// lastSeq is the greatest sequence number stored in the sstable
+func (h *Hino) commitFlush(sstable SStable, flushedWAL string, lastSeq uint64) error {
+	meta, err := newFileMeta(0, sstable)
+	if err != nil {
+		return err
+	}
+	meta.largestSeq = lastSeq
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	edit := versionEdit{added: []fileMeta{meta}, flushedWAL: flushedWAL, lastSequence: lastSeq}
+	if err := h.logAndApply(edit, sstable.FileSystem); err != nil {
+		return err
+	}
+	h.tables[sstable.Path()] = sstable
+	h.scheduleCompaction()
+	return nil
+}
+
+// commitWAL records the WAL segment number as live, it's
+// committed before the segment is created
+func (h *Hino) commitWAL(number uint64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.logAndApply(versionEdit{addedWALs: []uint64{number}})
+}
+
+// forgetWAL records the WAL named walName as flushed although
+// nothing was stored, it held no write
+func (h *Hino) forgetWAL(walName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.logAndApply(versionEdit{flushedWAL: walName})
+}
+
+// walSegments returns the numbers of the live WAL segments, ascending
+func (h *Hino) walSegments() []uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]uint64(nil), h.liveWALs...)
+}
+
+// isBottomLevel reports whether no file of levelNumb or deeper overlaps
+// [smallest, largest], so there is no older version left for a tombstone
+// of that range to shadow there
+func (h *Hino) isBottomLevel(levelNumb int, smallest, largest Bytes) (bool, error) {
+	for idx := levelNumb; idx < len(h.levels); idx++ {
+		files, err := h.overlappingFiles(idx, smallest, largest)
+		if err != nil || len(files) > 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// overlappingFiles returns the files of a level whose key range
+// overlaps [smallest, largest]
+func (h *Hino) overlappingFiles(levelNumb int, smallest, largest Bytes) ([]*FileSystem, error) {
+	if levelNumb >= len(h.levels) || h.levels[levelNumb] == nil {
+		return nil, nil
+	}
+
+	files := make([]*FileSystem, 0)
+	for _, fs := range h.levels[levelNumb].Values() {
+		fileSmallest, fileLargest, err := h.keyRange(fs)
+		if err != nil {
+			return nil, err
+		}
+		if Compare(fileLargest, smallest) != CmpLess && Compare(fileSmallest, largest) != CmpGreater {
+			files = append(files, fs)
+		}
+	}
+	return files, nil
+}
+
+// keyRange returns the key range of a file, from the MANIFEST when possible
+func (h *Hino) keyRange(fs *FileSystem) (Bytes, Bytes, error) {
+	if meta, ok := h.metas[fs.Path()]; ok {
+		return meta.smallest, meta.largest, nil
+	}
+
+	sstable, err := h.table(fs)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to load sstable %s", fs.Path())
+	}
+	smallest, largest := sstable.KeyRange()
+	return smallest, largest, nil
+}
+
+// addFile adds fs to a level using its metadata. Files of level 0 are
+// ordered from the oldest by their largest sequence numbers, files of
+// the deeper levels don't overlap, they are ordered by key range.
+// Files without metadata are appended.
+func (h *Hino) addFile(levelNumb int, fs *FileSystem) {
+	for len(h.levels) <= levelNumb {
+		h.levels = append(h.levels, InitLinkedList[*FileSystem]())
+	}
+	if h.levels[levelNumb] == nil {
+		h.levels[levelNumb] = InitLinkedList[*FileSystem]()
+	}
+
+	meta, ok := h.metas[fs.Path()]
+	if !ok || (levelNumb == 0 && meta.largestSeq == 0) {
+		h.levels[levelNumb].PushBack(fs)
+		return
+	}
+
+	goesBefore := func(next fileMeta) bool {
+		if levelNumb == 0 {
+			return meta.largestSeq < next.largestSeq
+		}
+		return Compare(meta.smallest, next.smallest) == CmpLess
+	}
+
+	iterator := h.levels[levelNumb].Iterator()
+	for iterator.HasNext() {
+		next, _ := iterator.NextValue()
+		if nextMeta, ok := h.metas[next.Path()]; ok && goesBefore(nextMeta) {
 			break
 		}
-		level := h.levels[levelNumb]
+		_, _ = iterator.Next()
+	}
+	iterator.InsertNext(fs)
+}
 
-		const bufferFileCount = 2
-		thresholdFileCount := levelNumb + bufferFileCount
-		pickedUpSSTable := make([]SStable, 0, thresholdFileCount)
+// table loads the sstable stored in fs, loaded sstables are cached
+func (h *Hino) table(fs *FileSystem) (SStable, error) {
+	h.tablesMu.Lock()
+	defer h.tablesMu.Unlock()
 
-		/*
-			how can we define and detect threshold properly?
-		*/
+	if sstable, ok := h.tables[fs.Path()]; ok {
+		return sstable, nil
+	}
 
-		levelIterator := level.Iterator()
-		for levelIterator.HasNext() {
-			if len(pickedUpSSTable) == thresholdFileCount {
-				newLevelNumb := levelNumb + 1
+	if !fs.IsOpened() {
+		if err := fs.Open(); err != nil {
+			return SStable{}, err
+		}
+	}
 
-				err := h.mergeSSTables(newLevelNumb, pickedUpSSTable)
-				if err != nil {
-					return err
-				}
+	sstable, err := NewSSTable(fs)
+	if err != nil {
+		return SStable{}, err
+	}
+	h.tables[fs.Path()] = sstable
+	return sstable, nil
+}
+
+// searchKey looks key up level by level. Files of level 0 are pushed back
+// in the order they are created, so they are visited backward to find
+// the newest version first. Files whose key range can't hold the key are
+// skipped, using the metadata of the MANIFEST to not open them. Files of
+// the deeper levels don't overlap, so only one of them is read per level.
+// Versions newer than seq are ignored, the returned record may be
+// a tombstone. Block checksums are checked with verify.
+func (h *Hino) searchKey(key Bytes, seq uint64, verify bool) (Record, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for levelNumb, level := range h.levels {
+		if level == nil {
+			continue
+		}
 
-				pickedUpSSTable = make([]SStable, 0)
+		files := level.Values()
+		for idx := len(files) - 1; idx >= 0; idx-- {
+			if meta, ok := h.metas[files[idx].Path()]; ok && !meta.contains(key) {
+				continue
 			}
 
-			fs, err := levelIterator.PickNext()
+			sstable, err := h.table(files[idx])
 			if err != nil {
-				return err
+				return nil, errors.Wrapf(err, "failed to load sstable %s", files[idx].Path())
 			}
 
-			if err := fs.Open(); err != nil {
-				return err
+			smallest, largest := sstable.KeyRange()
+			if Compare(key, smallest) == CmpLess || Compare(key, largest) == CmpGreater {
+				continue
+			}
+
+			record, err := sstable.lookup(key, seq, verify)
+			if errors.Is(err, ErrKeyNotFound) {
+				if levelNumb > 0 {
+					break
+				}
+				continue
 			}
+			return record, err
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// VerifyChecksums verifies every sstable of the levels, the errors of
+// all the corrupted ones are returned together
+func (h *Hino) VerifyChecksums() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 
-			sstable, err := NewSSTable(fs)
+	var errs []error
+	for _, level := range h.levels {
+		if level == nil {
+			continue
+		}
+
+		for _, fs := range level.Values() {
+			sstable, err := h.table(fs)
+			if err == nil {
+				err = sstable.Verify()
+			}
 			if err != nil {
-				return err
+				errs = append(errs, errors.Wrapf(err, "failed to verify sstable %s", fs.Path()))
 			}
+		}
+	}
+	return stderrors.Join(errs...)
+}
 
-			pickedUpSSTable = append(pickedUpSSTable, sstable)
+// userKeys returns the keys of [start, end) stored in the levels,
+// a key may be returned more than once
+func (h *Hino) userKeys(start, end Bytes) ([]Bytes, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	keys := make([]Bytes, 0)
+	for _, level := range h.levels {
+		if level == nil {
+			continue
 		}
 
-		for _, fs := range pickedUpSSTable {
-			level.PushBack(fs.FileSystem)
+		for _, fs := range level.Values() {
+			meta, ok := h.metas[fs.Path()]
+			if ok && (Compare(meta.largest, start) == CmpLess || Compare(meta.smallest, end) != CmpLess) {
+				continue
+			}
+
+			sstable, err := h.table(fs)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to load sstable %s", fs.Path())
+			}
+			tableKeys, err := sstable.userKeys(start, end)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read sstable %s", fs.Path())
+			}
+			keys = append(keys, tableKeys...)
+		}
+	}
+	return keys, nil
+}
+
+// newIterators returns an iterator over each sstable of the levels
+// overlapping bounds, the other ones are not opened. Every iterator opens
+// its own handle of the file once it's read, the files are pinned so the
+// ones removed by a compaction are still readable until the iterator
+// is closed.
+func (h *Hino) newIterators(bounds *iterBounds, verify bool) ([]internalIterator, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	iterators := make([]internalIterator, 0)
+	for _, level := range h.levels {
+		if level == nil {
+			continue
+		}
+
+		for _, fs := range level.Values() {
+			iterator, err := h.newTableIterator(fs, bounds, verify)
+			if err != nil {
+				for _, iterator := range iterators {
+					_ = iterator.Close()
+				}
+				return nil, err
+			}
+			if iterator != nil {
+				iterators = append(iterators, iterator)
+			}
+		}
+	}
+	return iterators, nil
+}
+
+// newTableIterator returns nil when the sstable is out of bounds
+func (h *Hino) newTableIterator(fs *FileSystem, bounds *iterBounds, verify bool) (*tableIterator, error) {
+	meta, ok := h.metas[fs.Path()]
+	if !ok {
+		sstable, err := h.table(fs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load sstable %s", fs.Path())
+		}
+		meta.smallest, meta.largest = sstable.KeyRange()
+	}
+	if !bounds.overlaps(meta.smallest, meta.largest) {
+		return nil, nil
+	}
+
+	filePath := fs.Path()
+	h.pin(filePath)
+	release := func() { h.unpin(filePath) }
+	return newTableIterator(filePath, meta.smallest, meta.largest, bounds, verify, release), nil
+}
+
+func (h *Hino) pin(filePath string) {
+	h.pinsMu.Lock()
+	defer h.pinsMu.Unlock()
+	h.pins[filePath]++
+}
+
+// unpin removes the sstable once its last iterator is closed, if it's
+// not part of the levels anymore
+func (h *Hino) unpin(filePath string) {
+	h.pinsMu.Lock()
+	defer h.pinsMu.Unlock()
+
+	h.pins[filePath]--
+	if h.pins[filePath] > 0 {
+		return
+	}
+	delete(h.pins, filePath)
+	if h.obsolete[filePath] {
+		delete(h.obsolete, filePath)
+		if err := os.Remove(filePath); err != nil {
+			h.log.ERROR("Error removing file %s: %v", filePath, err)
 		}
-		levelNumb += 1
 	}
-	return nil
 }
 
-func (h *Hino) mergeSSTables(newLevelNumb int, pickedUpSSTable []SStable) error {
-	newLevelSSTable, err := h.NewSSTableFS(newLevelNumb)
+// removeTable removes the file of a sstable dropped from the levels,
+// it's left to the last iterator reading it
+func (h *Hino) removeTable(filePath string) error {
+	h.pinsMu.Lock()
+	defer h.pinsMu.Unlock()
+
+	if h.pins[filePath] > 0 {
+		h.obsolete[filePath] = true
+		return nil
+	}
+	return os.Remove(filePath)
+}
+
+// mergeSSTables writes the newest version of every key of sources into
+// target. Tombstones are dropped when target goes to the bottom level.
+func mergeSSTables(target *FileSystem, sources []SStable, dropTombstones bool) (SStable, error) {
+	output := mergeOutput{
+		newFS:      func() (*FileSystem, error) { return target, nil },
+		opts:       defaultTableOptions(),
+		targetSize: math.MaxInt,
+	}
+	sstables, err := mergeTables(sources, dropTombstones, MaxSequence, output)
 	if err != nil {
-		return err
+		return SStable{}, err
+	}
+	if len(sstables) == 0 {
+		return SStable{}, errors.New("no record left after merging")
 	}
+	return sstables[0], nil
+}
 
-	if _, err := mergeSSTables(newLevelSSTable, pickedUpSSTable); err != nil {
-		return err
+// InitRinDB replays the WAL segments stored in dir. Keys which are not in
+// memory are looked up in hino, it may be nil to only serve the memtable.
+// The newest segment backs the memtable, the older ones were frozen but
+// not flushed yet so they are loaded as immutable memtables and flushed
+// again. Without hino, the segments are found by listing dir.
+func InitRinDB(dir string, hino *Hino, opts *Options) (*Rin, error) {
+	opts = opts.withDefaults()
+	r := &Rin{
+		dir:     dir,
+		opts:    opts,
+		log:     dbLogger{opts.Logger},
+		hino:    hino,
+		writers: list.New(),
 	}
+	r.writerTurn = sync.NewCond(&r.mu)
 
-	if len(h.levels) == newLevelNumb {
-		h.levels = append(h.levels, InitLinkedList[*FileSystem]())
+	if err := r.loadLegacyWALs(); err != nil {
+		return nil, err
+	}
+	if err := r.loadWALSegments(); err != nil {
+		return nil, err
 	}
-	h.levels[newLevelNumb].PushBack(newLevelSSTable)
 
-	// remove merged sstable
-	for _, sstable := range pickedUpSSTable {
-		if err := os.Remove(sstable.Path()); err != nil {
-			log.Printf("Error removing file %s: %v", sstable.Path(), err)
-		}
+	if hino != nil {
+		r.lastSeq = hino.lastSequence
 	}
-	return nil
+	for _, immutable := range r.immutables {
+		r.lastSeq = max(r.lastSeq, immutable.LastSeq())
+	}
+	r.lastSeq = max(r.lastSeq, r.memtable.LastSeq())
+
+	for _, immutable := range r.immutables {
+		r.scheduleFlush(immutable)
+	}
+	return r, nil
 }
 
-/*
-TODO:
--> to search key, so in the level we have to move backward
--> implement double ll remove the current single ll
--> so implement single -> double should be compatible, copy concept of list in built-in package
--> implement full tests for the linked list
-*/
-func (h *Hino) searchKey(key Bytes) (Bytes, error) {
-	return nil, nil
+func (r *Rin) openWAL(walPath string) (WAL, Memtable, error) {
+	fs, err := OpenFS(walPath)
+	if err != nil {
+		return WAL{}, Memtable{}, err
+	}
+
+	wal := r.newWAL(fs)
+	memtable, report, err := wal.Recover()
+	if err != nil {
+		_ = fs.Close()
+		return WAL{}, Memtable{}, err
+	}
+	r.recoveryReport.Corruptions = append(r.recoveryReport.Corruptions, report.Corruptions...)
+	return wal, memtable, nil
+}
+
+func (r *Rin) newWAL(fs *FileSystem) WAL {
+	wal := NewWAL(fs)
+	wal.manualFlush = r.opts.ManualWALFlush
+	wal.recoveryMode = r.opts.WALRecoveryMode
+	wal.log = r.log
+	return wal
 }
 
-func mergeSSTables(target *FileSystem, sources []SStable) (SStable, error) {
-	memtable := InitMemtable()
-	for _, sstable := range sources {
-		iterator, err := sstable.Iterator()
+// loadWALSegments replays the live WAL segments in number order, the
+// newest one becomes the WAL in use. A fresh segment is started when
+// there is none.
+func (r *Rin) loadWALSegments() error {
+	var numbers []uint64
+	if r.hino != nil {
+		numbers = r.hino.walSegments()
+	} else {
+		var err error
+		if numbers, err = listWALSegments(r.dir); err != nil {
+			return err
+		}
+	}
+
+	if len(numbers) == 0 {
+		wal, err := r.newSegment()
 		if err != nil {
-			return SStable{}, err
+			return err
 		}
-		for iterator.HasNext() {
-			record, err := iterator.Next()
-			if err != nil {
-				return SStable{}, err
+		r.wal = wal
+		r.memtable = InitMemtable()
+		return nil
+	}
+
+	for idx, number := range numbers {
+		walPath := path.Join(r.dir, walSegmentName(number))
+		wal, memtable, err := r.openWAL(walPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load WAL segment %s", walPath)
+		}
+		if idx == len(numbers)-1 {
+			r.wal = wal
+			r.memtable = memtable
+			r.walNumber = number
+			return nil
+		}
+
+		if err := wal.Close(); err != nil {
+			return err
+		}
+		if memtable.data.Len() == 0 {
+			if err := r.dropWAL(walPath); err != nil {
+				return err
 			}
-			// TODO: add logic/test ignore deleted record
-			memtable.Put(record.GetKey(), record.GetValue())
+			continue
 		}
+		r.immutables = append(r.immutables, &immutableMemtable{memtable, walPath})
 	}
-	sstable, err := Flush(memtable, target)
+	return nil
+}
+
+// dropWAL removes a WAL which held no write
+func (r *Rin) dropWAL(walPath string) error {
+	if r.hino != nil {
+		if err := r.hino.forgetWAL(path.Base(walPath)); err != nil {
+			return err
+		}
+	}
+	return os.Remove(walPath)
+}
+
+// newSegment starts the WAL segment following the one in use. It's
+// committed to the MANIFEST first so a crash never leaves a segment
+// which is not replayed.
+func (r *Rin) newSegment() (WAL, error) {
+	number := r.walNumber + 1
+	if r.hino != nil {
+		if err := r.hino.commitWAL(number); err != nil {
+			return WAL{}, err
+		}
+	}
+
+	fs, err := OpenFS(path.Join(r.dir, walSegmentName(number)))
 	if err != nil {
-		return SStable{}, err
+		return WAL{}, errors.Wrap(err, "failed to open WAL segment")
 	}
-	return sstable, nil
+	r.walNumber = number
+	return r.newWAL(fs), nil
 }
 
-func InitRinDB() (Rin, error) {
-	walPath := path.Join(dbDirectory, walName)
-	fs, err := OpenFS(walPath)
+// loadLegacyWALs loads the WALs written before the numbered segments as
+// immutable memtables, so they are flushed then retired
+func (r *Rin) loadLegacyWALs() error {
+	dirEntries, err := os.ReadDir(r.dir)
 	if err != nil {
-		return Rin{}, err
+		return err
 	}
 
-	wal := NewWAL(fs)
-	memtable, err := wal.Load()
+	// os.ReadDir sorts entries by name, so the oldest frozen WAL comes
+	// first and the WAL which was in use comes last
+	walPaths := make([]string, 0)
+	for _, dirEntry := range dirEntries {
+		if strings.HasPrefix(dirEntry.Name(), frozenWALPrefix) {
+			walPaths = append(walPaths, path.Join(r.dir, dirEntry.Name()))
+		}
+	}
+	if _, err := os.Stat(path.Join(r.dir, walName)); err == nil {
+		walPaths = append(walPaths, path.Join(r.dir, walName))
+	}
+
+	for _, walPath := range walPaths {
+		wal, memtable, err := r.openWAL(walPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load frozen WAL %s", walPath)
+		}
+		if err := wal.Close(); err != nil {
+			return err
+		}
+
+		if memtable.data.Len() == 0 {
+			if err := os.Remove(walPath); err != nil {
+				return err
+			}
+			continue
+		}
+		r.immutables = append(r.immutables, &immutableMemtable{memtable, walPath})
+	}
+	return nil
+}
+
+// Get looks key up in the memtable, then the immutable memtables from
+// the newest one, then the sstable levels. The first version found is
+// the newest one, a removed key stops the lookup there.
+func (r *Rin) Get(key Bytes) (Bytes, error) {
+	return r.get(key, MaxSequence, ReadOptions{})
+}
+
+// get is Get ignoring the versions newer than seq
+func (r *Rin) get(key Bytes, seq uint64, opts ReadOptions) (Bytes, error) {
+	record, err := r.lookup(key, seq, !opts.SkipChecksums)
 	if err != nil {
-		return Rin{}, err
+		return nil, err
+	}
+	if IsTombstone(record) {
+		return nil, ErrKeyNotFound
+	}
+	return record.GetValue(), nil
+}
+
+func (r *Rin) lookup(key Bytes, seq uint64, verify bool) (Record, error) {
+	memtable, immutables, lastSeq := r.readState()
+	seq = min(seq, lastSeq)
+
+	record, err := memtable.lookup(key, seq)
+	if !errors.Is(err, ErrKeyNotFound) {
+		return record, err
+	}
+
+	for idx := len(immutables) - 1; idx >= 0; idx-- {
+		record, err := immutables[idx].lookup(key, seq)
+		if !errors.Is(err, ErrKeyNotFound) {
+			return record, err
+		}
+	}
+
+	if r.hino == nil {
+		return nil, ErrKeyNotFound
+	}
+	return r.hino.searchKey(key, seq, verify)
+}
+
+// liveKeys returns the keys of [start, end) whose newest version
+// is not a tombstone
+func (r *Rin) liveKeys(start, end Bytes) ([]Bytes, error) {
+	memtable, immutables, _ := r.readState()
+	candidates := memtable.userKeys(start, end)
+	for _, immutable := range immutables {
+		candidates = append(candidates, immutable.userKeys(start, end)...)
+	}
+
+	if r.hino != nil {
+		keys, err := r.hino.userKeys(start, end)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, keys...)
+	}
+
+	seen := make(map[string]struct{}, len(candidates))
+	keys := make([]Bytes, 0, len(candidates))
+	for _, key := range candidates {
+		if _, ok := seen[string(key)]; ok {
+			continue
+		}
+		seen[string(key)] = struct{}{}
+
+		record, err := r.lookup(key, MaxSequence, true)
+		if err != nil {
+			return nil, err
+		}
+		if !IsTombstone(record) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// NewIterator returns an iterator over the keys visible at seq
+func (r *Rin) NewIterator(seq uint64, opts IterOptions) *DBIterator {
+	memtable, immutables, lastSeq := r.readState()
+
+	iterator := newDBIterator(min(seq, lastSeq), opts, r.opts.PrefixExtractor)
+	children := []internalIterator{memtable.newIterator()}
+	for idx := len(immutables) - 1; idx >= 0; idx-- {
+		children = append(children, immutables[idx].newIterator())
 	}
-	return Rin{
-		wal:      wal,
-		memtable: memtable,
-	}, nil
+
+	if r.hino != nil {
+		tables, err := r.hino.newIterators(iterator.bounds, !opts.SkipChecksums)
+		if err != nil {
+			iterator.err = err
+			return iterator
+		}
+		children = append(children, tables...)
+	}
+	iterator.iter.children = children
+	return iterator
+}
+
+// readState returns what reads look at: the memtable, the immutable
+// memtables and the sequence number of the latest visible write
+func (r *Rin) readState() (Memtable, []*immutableMemtable, uint64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.memtable, r.immutables, r.lastSeq
 }
 
-func (r Rin) Get(key Bytes) (Bytes, error) {
-	return r.memtable.Get(key)
+func (r *Rin) Put(key, value Bytes) error {
+	return r.put(key, value, r.writeOptions())
 }
 
-func (r Rin) Put(key, value Bytes) error {
+func (r *Rin) Remove(key Bytes) error {
+	return r.remove(key, r.writeOptions())
+}
+
+// Write applies the batch atomically, its records get consecutive
+// sequence numbers and are logged as one WAL entry
+func (r *Rin) Write(batch *WriteBatch) error {
+	return r.writeBatch(batch, r.writeOptions())
+}
+
+// writeOptions are the options of the writes done without any
+func (r *Rin) writeOptions() WriteOptions {
+	return WriteOptions{Sync: !r.opts.NoSync}
+}
+
+func (r *Rin) put(key, value Bytes, opts WriteOptions) error {
 	record := RecordImpl{Key: key, Value: value}
-	if err := r.wal.Append(record); err != nil {
+	return r.write(&writer{records: []RecordImpl{record}, opts: opts})
+}
+
+func (r *Rin) remove(key Bytes, opts WriteOptions) error {
+	record := RecordImpl{Key: key, Type: RecordTypeDelete}
+	return r.write(&writer{records: []RecordImpl{record}, opts: opts})
+}
+
+// writeBatch is Write tuned by opts. Range deletions are expanded in the
+// writer turn, so no write comes in between.
+func (r *Rin) writeBatch(batch *WriteBatch, opts WriteOptions) error {
+	if !batch.hasRangeDeletions() {
+		records, err := batch.records(r.liveKeys)
+		if err != nil || len(records) == 0 {
+			return err
+		}
+		return r.write(&writer{records: records, opts: opts})
+	}
+
+	return r.write(&writer{solo: func() error {
+		records, err := batch.records(r.liveKeys)
+		if err != nil {
+			return errors.Wrap(err, "failed to expand range deletions")
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		return r.commit([]*writer{{records: records, opts: opts}})
+	}})
+}
+
+// flushWAL writes the pending WAL entries, then fsyncs the WAL with sync
+func (r *Rin) flushWAL(sync bool) error {
+	return r.write(&writer{solo: func() error {
+		if err := r.wal.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush WAL")
+		}
+		if !sync {
+			return nil
+		}
+		return errors.Wrap(r.wal.Sync(), "failed to sync WAL")
+	}})
+}
+
+// writer is a write waiting in the writers queue
+type writer struct {
+	// records are logged as one WAL entry
+	records []RecordImpl
+	opts    WriteOptions
+
+	// solo is run alone in place of committing records
+	solo func() error
+
+	element *list.Element
+	done    bool
+	err     error
+}
+
+func (w *writer) size() int {
+	size := 0
+	for _, record := range w.records {
+		size += CalOnDiskSize(record)
+	}
+	return size
+}
+
+// write queues w and returns once it is committed. The writer at the front
+// of the queue leads a group made of its write and the ones queued behind
+// it, which are logged with a single WAL write and sync. Every writer of
+// the group returns once the group is durable, then the next writer leads.
+func (r *Rin) write(w *writer) error {
+	r.mu.Lock()
+	w.element = r.writers.PushBack(w)
+	for !w.done && r.writers.Front() != w.element {
+		r.writerTurn.Wait()
+	}
+	done, err := w.done, w.err
+	r.mu.Unlock()
+	if done {
+		return err
+	}
+
+	group := []*writer{w}
+	if w.solo != nil {
+		err = w.solo()
+	} else {
+		if r.opts.GroupCommitDelay > 0 {
+			time.Sleep(r.opts.GroupCommitDelay)
+		}
+		group = r.groupWriters(w)
+		err = r.commit(group)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, member := range group {
+		member.done, member.err = true, err
+		r.writers.Remove(member.element)
+	}
+	r.writerTurn.Broadcast()
+	return err
+}
+
+// groupWriters returns the leader and the writers queued behind it up to
+// Options.GroupCommitMaxSize bytes, a solo writer ends the group
+func (r *Rin) groupWriters(leader *writer) []*writer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	group := []*writer{leader}
+	size := leader.size()
+	for element := leader.element.Next(); element != nil; element = element.Next() {
+		w := element.Value.(*writer)
+		if w.solo != nil || size+w.size() > r.opts.GroupCommitMaxSize {
+			break
+		}
+		group = append(group, w)
+		size += w.size()
+	}
+	return group
+}
+
+// commit gives the records of the group the next sequence numbers, logs
+// the writes which don't disable the WAL with a single append, then puts
+// every record in the memtable. They are visible to reads once lastSeq
+// is moved past them. It's run by the leader of the group.
+func (r *Rin) commit(group []*writer) error {
+	r.mu.RLock()
+	bgErr := r.bgErr
+	r.mu.RUnlock()
+	if bgErr != nil {
+		return errors.Wrap(bgErr, "background flush failed")
+	}
+
+	// the group is synced when one of its logged writes asks for it
+	seq := r.lastSeq
+	sync := false
+	entries := make([][]Record, 0, len(group))
+	for _, w := range group {
+		logged := make([]Record, 0, len(w.records))
+		for idx := range w.records {
+			seq++
+			w.records[idx].Seq = seq
+			logged = append(logged, w.records[idx])
+		}
+		if w.opts.DisableWAL {
+			r.unlogged = true
+			continue
+		}
+		entries = append(entries, logged)
+		sync = sync || w.opts.Sync
+	}
+	if len(entries) > 0 {
+		if err := r.wal.AppendGroup(entries, sync); err != nil {
+			return err
+		}
+	}
+
+	for _, w := range group {
+		for _, record := range w.records {
+			r.memtable.PutRecord(record)
+		}
+	}
+	r.mu.Lock()
+	r.lastSeq = seq
+	r.mu.Unlock()
+
+	// the group is committed, a failed switch is tried again by
+	// the next group
+	if r.hino != nil && r.memtable.Size() >= r.opts.MemtableSize {
+		if err := r.freeze(); err != nil {
+			r.log.ERROR("Failed to freeze the memtable: %v", err)
+		}
+	}
+	return nil
+}
+
+// freeze turns the memtable into an immutable one and starts a fresh
+// memtable and WAL segment. The frozen memtable is flushed to level 0 in
+// the background, its segment is kept until then. The WAL in use is only
+// closed once the fresh segment replaced it, so a failed switch leaves
+// rin writing to it.
+func (r *Rin) freeze() error {
+	wal, err := r.newSegment()
+	if err != nil {
 		return err
 	}
-	r.memtable.Put(key, value)
+
+	frozenWAL := r.wal
+	immutable := &immutableMemtable{r.memtable, frozenWAL.Path()}
+	r.mu.Lock()
+	immutables := make([]*immutableMemtable, 0, len(r.immutables)+1)
+	r.immutables = append(append(immutables, r.immutables...), immutable)
+	r.wal = wal
+	r.memtable = InitMemtable()
+	r.mu.Unlock()
+	r.unlogged = false
+
+	// the frozen memtable is flushed whatever happens to its WAL
+	if err := frozenWAL.Close(); err != nil {
+		r.log.WARN("Failed to close frozen WAL %s: %v", immutable.walPath, err)
+	}
+	r.scheduleFlush(immutable)
 	return nil
 }
 
-func (r Rin) Remove(key Bytes) error {
-	record := RecordImpl{Key: key, Value: nil}
-	if err := r.wal.Append(record); err != nil {
+func (r *Rin) scheduleFlush(immutable *immutableMemtable) {
+	if r.hino == nil {
+		return
+	}
+
+	previousFlush := r.lastFlush
+	done := make(chan struct{})
+	r.lastFlush = done
+
+	r.flushes.Add(1)
+	go func() {
+		defer r.flushes.Done()
+		defer close(done)
+		if previousFlush != nil {
+			<-previousFlush
+		}
+
+		r.mu.Lock()
+		bgErr := r.bgErr
+		r.mu.Unlock()
+		if bgErr != nil {
+			// keep the memtable and its WAL, they are loaded again on next open
+			return
+		}
+
+		if err := r.flush(immutable); err != nil {
+			r.log.ERROR("Failed to flush memtable of %s: %v", immutable.walPath, err)
+			r.mu.Lock()
+			r.bgErr = err
+			r.mu.Unlock()
+		}
+	}()
+}
+
+// flush writes the immutable memtable to a new level 0 sstable. Reads keep
+// seeing the immutable memtable until the sstable is added to level 0.
+func (r *Rin) flush(immutable *immutableMemtable) error {
+	fs, err := r.hino.NewSSTableFS(0)
+	if err != nil {
+		return err
+	}
+
+	sstable, err := writeSSTable(immutable.Memtable, fs, r.hino.tableOptions(0))
+	if err == nil {
+		err = r.hino.commitFlush(sstable, path.Base(immutable.walPath), immutable.LastSeq())
+	}
+	if err != nil {
+		_ = fs.Close()
+		_ = os.Remove(fs.Path())
 		return err
 	}
-	r.memtable.Put(record.GetKey(), record.GetValue())
+
+	// readers may hold the current slice, so a new one is built
+	r.mu.Lock()
+	immutables := make([]*immutableMemtable, 0, len(r.immutables))
+	for _, imm := range r.immutables {
+		if imm != immutable {
+			immutables = append(immutables, imm)
+		}
+	}
+	r.immutables = immutables
+	r.mu.Unlock()
+
+	if err := retireWAL(immutable.walPath, r.opts); err != nil {
+		r.log.WARN("Failed to retire flushed WAL %s: %v", immutable.walPath, err)
+	}
 	return nil
 }
+
+// Close flushes the memtable when it holds writes done without the WAL,
+// waits for the background flushes and releases the WAL of rin. Calling
+// it again does nothing.
+func (r *Rin) Close() error {
+	return r.write(&writer{solo: func() error {
+		if r.closed {
+			return nil
+		}
+		r.closed = true
+
+		if r.unlogged && r.hino != nil {
+			if err := r.freeze(); err != nil {
+				return err
+			}
+		}
+		r.flushes.Wait()
+		return r.wal.Close()
+	}})
+}
@@ -2,25 +2,32 @@
 package rindb
 
 import (
-	"container/list"
 	"fmt"
 	"os"
+	"path"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/oklog/ulid/v2"
 	"github.com/stretchr/testify/assert"
 )
 
 //nolint:funlen
 func TestRin(t *testing.T) {
+	dir := t.TempDir()
+
 	t.Run("init::rindb", func(t *testing.T) {
-		_, err := InitRinDB()
+		rin, err := InitRinDB(dir, nil, nil)
 		assert.NoError(t, err)
+		assert.NoError(t, rin.Close())
 	})
 
 	t.Run("rindb::put", func(t *testing.T) {
-		rin, err := InitRinDB()
+		rin, err := InitRinDB(dir, nil, nil)
 		assert.NoError(t, err)
+		defer func() { _ = rin.Close() }()
 
 		err = rin.Put(Bytes("key"), Bytes("value"))
 		assert.NoError(t, err)
@@ -28,8 +35,9 @@ func TestRin(t *testing.T) {
 
 	t.Run("rindb::get", func(t *testing.T) {
 		key := Bytes("key")
-		rin, err := InitRinDB()
+		rin, err := InitRinDB(dir, nil, nil)
 		assert.NoError(t, err)
+		defer func() { _ = rin.Close() }()
 
 		value, err := rin.Get(key)
 		assert.NoError(t, err)
@@ -37,8 +45,9 @@ func TestRin(t *testing.T) {
 	})
 
 	t.Run("rindb::remove", func(t *testing.T) {
-		rin, err := InitRinDB()
+		rin, err := InitRinDB(dir, nil, nil)
 		assert.NoError(t, err)
+		defer func() { _ = rin.Close() }()
 
 		key := Bytes("rm-key")
 		err = rin.Put(key, Bytes("value"))
@@ -48,13 +57,14 @@ func TestRin(t *testing.T) {
 		assert.NoError(t, err)
 
 		value, err := rin.Get(key)
-		assert.NoError(t, err)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
 		assert.Equal(t, Bytes(nil), value)
 	})
 
 	t.Run("flush memtable to sstable", func(t *testing.T) {
-		rin, err := InitRinDB()
+		rin, err := InitRinDB(dir, nil, nil)
 		assert.NoError(t, err)
+		defer func() { _ = rin.Close() }()
 
 		err = rin.Put(Bytes("key"), Bytes("value"))
 		assert.NoError(t, err)
@@ -63,7 +73,7 @@ func TestRin(t *testing.T) {
 		err = rin.Remove(Bytes("rm-key"))
 		assert.NoError(t, err)
 
-		hino, err := InitHino()
+		hino, err := InitHino(dir, nil)
 		assert.NoError(t, err)
 		defer hino.Close()
 
@@ -78,7 +88,7 @@ func TestRin(t *testing.T) {
 		assert.NoError(t, err)
 
 		value, err := newSStable.GetValue(Bytes("rm-key"))
-		assert.NoError(t, err)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
 		assert.Equal(t, Bytes(nil), value)
 
 		value, err = newSStable.GetValue(Bytes("key"))
@@ -89,7 +99,7 @@ func TestRin(t *testing.T) {
 
 func TestHino(t *testing.T) {
 	t.Run("hino::LoadLevels", func(t *testing.T) {
-		hino, err := InitHino()
+		hino, err := InitHino(t.TempDir(), nil)
 		assert.NoError(t, err)
 		assert.NoError(t, hino.Compact())
 		defer hino.Close()
@@ -106,61 +116,172 @@ func TestHino(t *testing.T) {
 		}
 	})
 
-	t.Run("hino::Compact", func(t *testing.T) {
-		/*
-		   Compact logic:
-		   - 1 lvl0 <-(compact)- 1 lvl0 -> 01 lvl0
-		   - 1 lvl1 <-(compact)- 2 lvl0 -> 02 lvl0
-		   - 1 lvl2 <-(compact)- 3 lvl1 -> 06 lvl0
-		   - 1 lvl3 <-(compact)- 4 lvl2 -> 24 lvl0
-		   --------------------------------[Total]
-		                                   33 lvl0
-		*/
-		fss, closer := initTempFileSystems(t, 33)
-		defer closer()
-
-		h := &Hino{openedFs: list.New()}
+	t.Run("hino::Compact level 0 by file count", func(t *testing.T) {
+		h := newHino(t.TempDir(), &Options{LevelFileThreshold: 4})
 		defer h.Close()
 
-		h.levels = []*LinkedList[*FileSystem]{
-			InitLinkedList[*FileSystem](),
+		for i := 0; i < 3; i++ {
+			flushToLevel(t, h, 0, "1", "2", "3", "4")
 		}
+		assert.NoError(t, h.Compact())
+		assert.Equal(t, 3, h.levels[0].Len())
+		assert.Len(t, h.levels, 1)
+
+		flushToLevel(t, h, 0, "5", "6")
+		fss := h.levels[0].Values()
+		assert.InDelta(t, 1.0, h.levelScore(0), 0)
+		assert.NoError(t, h.Compact())
+		assert.Equal(t, 0, h.levels[0].Len())
+		assert.Equal(t, 1, h.levels[1].Len())
 
 		for _, fs := range fss {
-			memtable := InitMemtable()
-			memtable.Put(Bytes("1"), Bytes("2"))
-			memtable.Put(Bytes("3"), Bytes("4"))
-			memtable.Put(Bytes("2"), Bytes("3"))
-			_, err := Flush(memtable, fs)
+			_, err := os.Stat(fs.Path())
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		}
+		for key, value := range map[string]string{"1": "2", "3": "4", "5": "6"} {
+			record, err := h.searchKey(Bytes(key), MaxSequence, true)
 			assert.NoError(t, err)
-			h.levels[0].PushBack(fs)
+			assert.Equal(t, Bytes(value), record.GetValue())
 		}
+	})
 
-		err := h.Compact()
-		assert.NoError(t, err)
-
-		assert.Equal(t, 1, h.levels[0].Len())
-		assert.Equal(t, 1, h.levels[1].Len())
-		assert.Equal(t, 1, h.levels[2].Len())
-		assert.Equal(t, 1, h.levels[3].Len())
+	t.Run("hino::Compact deeper levels by size", func(t *testing.T) {
+		h := newHino(t.TempDir(), &Options{LevelFileThreshold: 100, LevelSizeMultiplier: 2})
+		defer h.Close()
 
-		for _, fs := range fss {
-			_, err := os.Stat(fs.Path())
-			// Only last fs in level 0 hasn't compacted, so it should be existed
-			if h.levels[0].lastNode.Value.Path() == fs.Path() {
-				assert.NoError(t, err)
-			} else {
-				assert.Error(t, err)
-				assert.Contains(t, err.Error(), "no such file or directory")
+		for i := 0; i < 12; i++ {
+			kvs := make([]string, 0)
+			for j := 0; j < 10; j++ {
+				kvs = append(kvs, fmt.Sprintf("key%03d", i*10+j), "value")
 			}
+			flushToLevel(t, h, 1, kvs...)
+		}
+		// level 1 holds about 3 files, level 2 about 6
+		h.opts.BaseLevelSize = 3 * h.levelSize(1) / 12
+		assert.Greater(t, h.levelScore(1), 1.0)
+
+		assert.NoError(t, h.Compact())
+		assert.Greater(t, len(h.levels), 2)
+		for levelNumb := range h.levels {
+			assert.Less(t, h.levelScore(levelNumb), 1.0)
+		}
+		for i := 0; i < 120; i++ {
+			record, err := h.searchKey(Bytes(fmt.Sprintf("key%03d", i)), MaxSequence, true)
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes("value"), record.GetValue())
 		}
 	})
 }
 
+func TestHino_backgroundCompaction(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableSize: 256, LevelFileThreshold: 2, NoSync: true})
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+
+	for i := 0; i < 500; i++ {
+		assert.NoError(t, db.Put(Bytes(fmt.Sprintf("key%03d", i%100)), Bytes(fmt.Sprint(i))))
+	}
+	db.rin.flushes.Wait()
+
+	assert.Eventually(t, func() bool {
+		db.hino.mu.Lock()
+		defer db.hino.mu.Unlock()
+
+		c, err := db.hino.pickCompaction()
+		return err == nil && c == nil && len(db.hino.levels) > 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for i := 400; i < 500; i++ {
+		value, err := db.Get(Bytes(fmt.Sprintf("key%03d", i%100)))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes(fmt.Sprint(i)), value)
+	}
+}
+
 //nolint:funlen
+func TestHino_pinnedTables(t *testing.T) {
+	db := tenantDB(t)
+	defer func() { assert.NoError(t, db.Close()) }()
+
+	// the iterator opens its sstables once read, after the compaction
+	it := db.NewIterator(IterOptions{})
+	compacted := db.hino.levels[0].Values()
+	assert.NoError(t, db.hino.Compact())
+	assert.Zero(t, db.hino.levels[0].Len())
+	for _, fs := range compacted {
+		assert.FileExists(t, fs.Path())
+	}
+
+	it.SeekToFirst()
+	assert.Len(t, scan(it, true), 30)
+	assert.NoError(t, it.Close())
+	for _, fs := range compacted {
+		assert.NoFileExists(t, fs.Path())
+	}
+	assert.Empty(t, db.hino.pins)
+}
+
 func Test_mergeSSTables(t *testing.T) {
+	t.Run("tombstones are kept unless dropped", func(t *testing.T) {
+		fss, closer := initTempFileSystems(t, 4)
+		defer closer()
+
+		memtable := InitMemtable()
+		memtable.Put(Bytes("1"), Bytes("1"))
+		memtable.Put(Bytes("2"), Bytes("2"))
+		older, err := Flush(memtable, fss[0])
+		assert.NoError(t, err)
+
+		memtable.Delete(Bytes("1"))
+		newer, err := Flush(memtable, fss[1])
+		assert.NoError(t, err)
+
+		sstable, err := mergeSSTables(fss[2], []SStable{older, newer}, false)
+		assert.NoError(t, err)
+		record, err := sstable.lookup(Bytes("1"), MaxSequence, true)
+		assert.NoError(t, err)
+		assert.True(t, IsTombstone(record))
+
+		sstable, err = mergeSSTables(fss[3], []SStable{older, newer}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, keyCount(t, sstable))
+
+		_, err = sstable.GetValue(Bytes("1"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		value, err := sstable.GetValue(Bytes("2"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("2"), value)
+	})
+
+	t.Run("tombstones reaching the bottom level are dropped", func(t *testing.T) {
+		h := newHino(t.TempDir(), &Options{LevelFileThreshold: 2})
+		defer h.Close()
+
+		flushToLevel(t, h, 0, "a", "1", "b", "2")
+		fs, err := h.NewSSTableFS(0)
+		assert.NoError(t, err)
+		memtable := InitMemtable()
+		memtable.Delete(Bytes("a"))
+		_, err = Flush(memtable, fs)
+		assert.NoError(t, err)
+		h.addFile(0, fs)
+		flushToLevel(t, h, 0, "c", "3")
+
+		assert.NoError(t, h.Compact())
+		assert.Equal(t, 1, h.levels[1].Len())
+
+		// level 0 is merged as a whole, only b and c are left
+		sstable, err := h.table(h.levels[1].Values()[0])
+		assert.NoError(t, err)
+		assert.Equal(t, 2, keyCount(t, sstable))
+
+		record, err := h.searchKey(Bytes("a"), MaxSequence, true)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		assert.Nil(t, record)
+	})
+
 	t.Run("merging sstables", func(t *testing.T) {
-		hino := Hino{openedFs: list.New()}
+		hino := newHino(t.TempDir(), nil)
 		defer hino.Close()
 
 		fss, closer := initTempFileSystems(t, 4)
@@ -189,9 +310,9 @@ func Test_mergeSSTables(t *testing.T) {
 		sstables = append(sstables, sstable3)
 
 		fs := fss[3]
-		newSSTable, err := mergeSSTables(fs, sstables)
+		newSSTable, err := mergeSSTables(fs, sstables, false)
 		assert.NoError(t, err)
-		assert.Equal(t, 5, len(newSSTable.SparseIndex))
+		assert.Equal(t, 5, keyCount(t, newSSTable))
 
 		sstableIterator, err := newSSTable.Iterator()
 		assert.NoError(t, err)
@@ -232,3 +353,357 @@ func Test_mergeSSTables(t *testing.T) {
 		assert.Nil(t, record)
 	})
 }
+
+// flushToLevel writes the key-value pairs to a new sstable of the level
+func flushToLevel(t *testing.T, h *Hino, levelNumb int, kvs ...string) {
+	memtable := InitMemtable()
+	for i := 0; i+1 < len(kvs); i += 2 {
+		memtable.Put(Bytes(kvs[i]), Bytes(kvs[i+1]))
+	}
+
+	fs, err := h.NewSSTableFS(levelNumb)
+	assert.NoError(t, err)
+	_, err = Flush(memtable, fs)
+	assert.NoError(t, err)
+	h.addFile(levelNumb, fs)
+}
+
+func TestRin_Get(t *testing.T) {
+	dir := t.TempDir()
+	hino := newHino(dir, nil)
+	defer hino.Close()
+
+	flushToLevel(t, hino, 1, "a", "l1", "b", "l1", "c", "l1")
+	flushToLevel(t, hino, 1, "x", "l1", "z", "l1")
+	flushToLevel(t, hino, 0, "a", "l0-old", "b", "l0-old")
+	flushToLevel(t, hino, 0, "a", "l0-new")
+
+	rin, err := InitRinDB(dir, hino, nil)
+	assert.NoError(t, err)
+	defer func() { _ = rin.Close() }()
+
+	immutable := InitMemtable()
+	immutable.Put(Bytes("c"), Bytes("immutable"))
+	rin.immutables = append(rin.immutables, &immutableMemtable{Memtable: immutable})
+
+	assert.NoError(t, rin.Put(Bytes("d"), Bytes("memtable")))
+	assert.NoError(t, rin.Remove(Bytes("z")))
+
+	tests := []struct {
+		key     string
+		want    Bytes
+		wantErr error
+	}{
+		{key: "a", want: Bytes("l0-new")},
+		{key: "b", want: Bytes("l0-old")},
+		{key: "c", want: Bytes("immutable")},
+		{key: "d", want: Bytes("memtable")},
+		{key: "x", want: Bytes("l1")},
+		{key: "z", wantErr: ErrKeyNotFound},
+		{key: "y", wantErr: ErrKeyNotFound},
+		{key: "0", wantErr: ErrKeyNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := rin.Get(Bytes(tt.key))
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// levelLen returns the number of files of a level, flushes may add some
+func levelLen(h *Hino, levelNumb int) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.levels[levelNumb].Len()
+}
+
+func walFiles(t *testing.T, dir string) []string {
+	dirEntries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+
+	names := make([]string, 0)
+	for _, dirEntry := range dirEntries {
+		if strings.HasPrefix(dirEntry.Name(), walName) {
+			names = append(names, dirEntry.Name())
+		}
+	}
+	return names
+}
+
+//nolint:funlen
+func TestRin_groupCommit(t *testing.T) {
+	t.Run("queued writers are grouped up to the max size", func(t *testing.T) {
+		rin, err := InitRinDB(t.TempDir(), nil, &Options{GroupCommitMaxSize: 200})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, rin.Close()) }()
+
+		queue := func(writers ...*writer) {
+			for _, w := range writers {
+				w.element = rin.writers.PushBack(w)
+			}
+		}
+		record := func(size int) []RecordImpl {
+			return []RecordImpl{{Key: Bytes("key"), Value: make(Bytes, size)}}
+		}
+
+		// every record takes 28 bytes more than its value
+		leader := &writer{records: record(40)}
+		followers := []*writer{{records: record(40)}, {records: record(20)}}
+		queue(leader)
+		queue(followers...)
+		queue(&writer{records: record(50)})
+		assert.Equal(t, append([]*writer{leader}, followers...), rin.groupWriters(leader))
+
+		// a leader larger than the max size is committed alone
+		rin.writers.Init()
+		leader = &writer{records: record(500)}
+		queue(leader, &writer{records: record(1)})
+		assert.Equal(t, []*writer{leader}, rin.groupWriters(leader))
+
+		// a solo writer is never grouped
+		rin.writers.Init()
+		leader = &writer{records: record(1)}
+		queue(leader, &writer{solo: func() error { return nil }}, &writer{records: record(1)})
+		assert.Equal(t, []*writer{leader}, rin.groupWriters(leader))
+		rin.writers.Init()
+	})
+
+	t.Run("concurrent writes are durable once returned", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{GroupCommitDelay: time.Millisecond}
+		db, err := Open(dir, opts)
+		assert.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if i%5 == 0 {
+					batch := NewWriteBatch()
+					batch.Put(Bytes(fmt.Sprintf("key%02d", i)), Bytes(fmt.Sprint(i)))
+					batch.Put(Bytes(fmt.Sprintf("batch%02d", i)), Bytes(fmt.Sprint(i)))
+					assert.NoError(t, db.Write(batch))
+					return
+				}
+				assert.NoError(t, db.Put(Bytes(fmt.Sprintf("key%02d", i)), Bytes(fmt.Sprint(i))))
+			}(i)
+		}
+		wg.Wait()
+		assert.NoError(t, db.Close())
+
+		db, err = Open(dir, opts)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+		assert.Equal(t, uint64(60), db.rin.lastSeq)
+		for i := 0; i < 50; i++ {
+			value, err := db.Get(Bytes(fmt.Sprintf("key%02d", i)))
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes(fmt.Sprint(i)), value)
+		}
+	})
+}
+
+//nolint:funlen
+func TestRin_Flush(t *testing.T) {
+	t.Run("full memtable is flushed to level 0", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{MemtableSize: 64, NoSync: true}
+		hino := newHino(dir, opts)
+		defer hino.Close()
+
+		rin, err := InitRinDB(dir, hino, opts)
+		assert.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			err := rin.Put(Bytes(fmt.Sprintf("key%03d", i)), Bytes(fmt.Sprintf("value%03d", i)))
+			assert.NoError(t, err)
+		}
+		rin.flushes.Wait()
+
+		assert.Empty(t, rin.immutables)
+		assert.Equal(t, []string{path.Base(rin.wal.Path())}, walFiles(t, dir))
+		assert.Greater(t, rin.walNumber, uint64(1))
+		assert.Less(t, rin.memtable.Size(), opts.MemtableSize)
+		assert.Greater(t, hino.levels[0].Len(), 1)
+
+		for i := 0; i < 100; i++ {
+			value, err := rin.Get(Bytes(fmt.Sprintf("key%03d", i)))
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes(fmt.Sprintf("value%03d", i)), value)
+		}
+		assert.NoError(t, rin.Close())
+	})
+
+	t.Run("WALs written before the segments are flushed on init", func(t *testing.T) {
+		dir := t.TempDir()
+		walKeys := map[string]string{frozenWALPrefix + ulid.Make().String(): "frozen", walName: "active"}
+		for name, key := range walKeys {
+			fs, err := OpenFS(path.Join(dir, name))
+			assert.NoError(t, err)
+			wal := NewWAL(fs)
+			assert.NoError(t, wal.Append(RecordImpl{Key: Bytes(key), Value: Bytes("value")}))
+			assert.NoError(t, wal.Close())
+		}
+
+		hino, err := InitHino(dir, &Options{DisableAutoCompaction: true})
+		assert.NoError(t, err)
+		defer hino.Close()
+
+		rin, err := InitRinDB(dir, hino, nil)
+		assert.NoError(t, err)
+		rin.flushes.Wait()
+
+		assert.Equal(t, []string{walSegmentName(1)}, walFiles(t, dir))
+		assert.Equal(t, 2, levelLen(hino, 0))
+		assert.Zero(t, rin.memtable.data.Len())
+
+		for _, key := range walKeys {
+			value, err := rin.Get(Bytes(key))
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes("value"), value)
+		}
+		assert.NoError(t, rin.Close())
+	})
+
+	t.Run("flushed segments are archived", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{MemtableSize: 64, NoSync: true, WALArchiveDir: path.Join(dir, "archive")}
+		hino := newHino(dir, opts)
+		defer hino.Close()
+
+		rin, err := InitRinDB(dir, hino, opts)
+		assert.NoError(t, err)
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, rin.Put(Bytes(fmt.Sprintf("key%03d", i)), Bytes("value")))
+		}
+		rin.flushes.Wait()
+
+		archived := walFiles(t, opts.WALArchiveDir)
+		assert.Len(t, archived, int(rin.walNumber)-1)
+		assert.Equal(t, walSegmentName(1), archived[0])
+		assert.Equal(t, []string{path.Base(rin.wal.Path())}, walFiles(t, dir))
+		assert.NoError(t, rin.Close())
+	})
+}
+
+//nolint:funlen
+func TestRin_walSegments(t *testing.T) {
+	writeSegment := func(t *testing.T, dir string, number uint64, records ...RecordImpl) {
+		fs, err := OpenFS(path.Join(dir, walSegmentName(number)))
+		assert.NoError(t, err)
+		wal := NewWAL(fs)
+		for _, record := range records {
+			assert.NoError(t, wal.Append(record))
+		}
+		assert.NoError(t, wal.Close())
+	}
+
+	t.Run("segments are replayed in order on open", func(t *testing.T) {
+		dir := t.TempDir()
+		hino, err := InitHino(dir, &Options{DisableAutoCompaction: true})
+		assert.NoError(t, err)
+		defer hino.Close()
+
+		// a crash left three segments, the first one held no write
+		for number := uint64(1); number <= 4; number++ {
+			assert.NoError(t, hino.commitWAL(number))
+		}
+		writeSegment(t, dir, 1)
+		writeSegment(t, dir, 2, RecordImpl{Key: Bytes("a"), Value: Bytes("1"), Seq: 1})
+		writeSegment(t, dir, 3,
+			RecordImpl{Key: Bytes("a"), Value: Bytes("2"), Seq: 2},
+			RecordImpl{Key: Bytes("b"), Value: Bytes("2"), Seq: 3})
+		writeSegment(t, dir, 4, RecordImpl{Key: Bytes("b"), Value: Bytes("3"), Seq: 4})
+
+		rin, err := InitRinDB(dir, hino, nil)
+		assert.NoError(t, err)
+		rin.flushes.Wait()
+
+		assert.Equal(t, uint64(4), rin.walNumber)
+		assert.Equal(t, uint64(4), rin.lastSeq)
+		assert.Equal(t, []uint64{4}, hino.walSegments())
+		assert.Equal(t, []string{walSegmentName(4)}, walFiles(t, dir))
+		assert.Equal(t, 2, levelLen(hino, 0))
+		assert.Equal(t, uint(1), rin.memtable.data.Len())
+
+		for key, want := range map[string]string{"a": "2", "b": "3"} {
+			value, err := rin.Get(Bytes(key))
+			assert.NoError(t, err)
+			assert.Equal(t, Bytes(want), value)
+		}
+
+		// the next segment follows the replayed ones
+		assert.NoError(t, rin.freeze())
+		rin.flushes.Wait()
+		assert.Equal(t, []uint64{5}, hino.walSegments())
+		assert.NoError(t, rin.Close())
+	})
+
+	t.Run("segments are committed to the manifest", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{MemtableSize: 64, NoSync: true}
+		db, err := Open(dir, opts)
+		assert.NoError(t, err)
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, db.Put(Bytes(fmt.Sprintf("key%03d", i)), Bytes("value")))
+		}
+		db.rin.flushes.Wait()
+		walNumber := db.rin.walNumber
+		assert.Equal(t, []uint64{walNumber}, db.hino.walSegments())
+		assert.NoError(t, db.Close())
+
+		db, err = Open(dir, opts)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+		assert.Equal(t, walNumber, db.rin.walNumber)
+		for i := 0; i < 20; i++ {
+			_, err := db.Get(Bytes(fmt.Sprintf("key%03d", i)))
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("a failed switch keeps the WAL in use", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{MemtableSize: 64, NoSync: true, DisableAutoCompaction: true}
+		hino, err := InitHino(dir, opts)
+		assert.NoError(t, err)
+		defer hino.Close()
+
+		rin, err := InitRinDB(dir, hino, opts)
+		assert.NoError(t, err)
+
+		// the next segment can't be opened
+		assert.NoError(t, os.Mkdir(path.Join(dir, walSegmentName(2)), 0o755))
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, rin.Put(Bytes(fmt.Sprintf("key%d", i)), make(Bytes, 32)))
+		}
+		assert.Equal(t, uint64(1), rin.walNumber)
+		assert.Empty(t, rin.immutables)
+
+		assert.NoError(t, os.Remove(path.Join(dir, walSegmentName(2))))
+		assert.NoError(t, rin.Put(Bytes("key5"), Bytes("value")))
+		rin.flushes.Wait()
+		assert.Equal(t, uint64(2), rin.walNumber)
+		assert.Equal(t, 1, levelLen(hino, 0))
+		assert.NoError(t, rin.Close())
+	})
+
+	t.Run("segments are listed without hino", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSegment(t, dir, 2, RecordImpl{Key: Bytes("a"), Value: Bytes("1"), Seq: 1})
+		writeSegment(t, dir, 10, RecordImpl{Key: Bytes("a"), Value: Bytes("2"), Seq: 2})
+
+		rin, err := InitRinDB(dir, nil, nil)
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, rin.Close()) }()
+
+		assert.Equal(t, uint64(10), rin.walNumber)
+		assert.Len(t, rin.immutables, 1)
+		value, err := rin.Get(Bytes("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("2"), value)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"errors"
 	"math/big"
+	"sync"
 )
 
 const (
@@ -21,13 +22,21 @@ type SLNode[K Comparable, V any] struct {
 	Key      K
 	Value    V
 	forwards []*SLNode[K, V]
+
+	// mu is the lock of the list holding the node
+	mu *sync.RWMutex
 }
 
 func (n *SLNode[K, V]) Next() *SLNode[K, V] {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
 	return n.forwards[0]
 }
 
+// SkipList is safe for concurrent use, readers go on in parallel
+// while a writer holds the list alone
 type SkipList[K Comparable, V any] struct {
+	mu       *sync.RWMutex
 	level    uint
 	length   uint
 	headNote *SLNode[K, V]
@@ -40,14 +49,19 @@ func InitSkipList[K Comparable, V any]() (*SkipList[K, V], error) {
 		return nil, err
 	}
 
+	mu := &sync.RWMutex{}
 	return &SkipList[K, V]{
+		mu:       mu,
 		level:    DefaultLevel,
-		headNote: &SLNode[K, V]{forwards: make([]*SLNode[K, V], DefaultLevel)},
+		headNote: &SLNode[K, V]{forwards: make([]*SLNode[K, V], DefaultLevel), mu: mu},
 	}, nil
 }
 
 func (list *SkipList[K, V]) Put(searchKey K, newValue V) {
-	rn := list.Head()
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	rn := list.headNote
 	rl := list.level
 	update := make([]*SLNode[K, V], MaxLevel)
 	for rl > 0 {
@@ -69,7 +83,7 @@ func (list *SkipList[K, V]) Put(searchKey K, newValue V) {
 			rl := newLevel
 			for rl > list.level {
 				rl--
-				update[rl] = list.Head()
+				update[rl] = list.headNote
 				update[rl].forwards = append(update[rl].forwards, make([]*SLNode[K, V], newLevel-list.level)...)
 			}
 			list.level = newLevel
@@ -78,6 +92,7 @@ func (list *SkipList[K, V]) Put(searchKey K, newValue V) {
 			Key:      searchKey,
 			Value:    newValue,
 			forwards: make([]*SLNode[K, V], list.level),
+			mu:       list.mu,
 		}
 		for newLevel > 0 {
 			newLevel--
@@ -90,7 +105,10 @@ func (list *SkipList[K, V]) Put(searchKey K, newValue V) {
 }
 
 func (list *SkipList[K, V]) Get(searchKey K) (V, error) {
-	rn := list.Head()
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+
+	rn := list.headNote
 	rl := list.level
 
 	for rl > 0 {
@@ -110,16 +128,83 @@ func (list *SkipList[K, V]) Get(searchKey K) (V, error) {
 	}
 }
 
+// Seek returns the first node whose key is greater than or equal
+// to searchKey, nil when there is no such node
+func (list *SkipList[K, V]) Seek(searchKey K) *SLNode[K, V] {
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+
+	rn := list.headNote
+	rl := list.level
+
+	for rl > 0 {
+		rl--
+		for rn.forwards[rl] != nil && Compare(rn.forwards[rl].Key, searchKey) == CmpLess {
+			rn = rn.forwards[rl]
+		}
+	}
+	return rn.forwards[0]
+}
+
+// SeekBefore returns the last node whose key is less than searchKey,
+// nil when there is no such node
+func (list *SkipList[K, V]) SeekBefore(searchKey K) *SLNode[K, V] {
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+
+	rn := list.headNote
+	rl := list.level
+
+	for rl > 0 {
+		rl--
+		for rn.forwards[rl] != nil && Compare(rn.forwards[rl].Key, searchKey) == CmpLess {
+			rn = rn.forwards[rl]
+		}
+	}
+	if rn == list.headNote {
+		return nil
+	}
+	return rn
+}
+
+// Last returns the node holding the greatest key, nil when the list is empty
+func (list *SkipList[K, V]) Last() *SLNode[K, V] {
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+
+	rn := list.headNote
+	rl := list.level
+
+	for rl > 0 {
+		rl--
+		for rn.forwards[rl] != nil {
+			rn = rn.forwards[rl]
+		}
+	}
+	if rn == list.headNote {
+		return nil
+	}
+	return rn
+}
+
 func (list *SkipList[K, V]) Head() *SLNode[K, V] {
-	if list == nil || list.headNote == nil {
+	if list == nil {
 		panic(ErrMalformedList)
 	}
 
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+	if list.headNote == nil {
+		panic(ErrMalformedList)
+	}
 	return list.headNote
 }
 
 func (list *SkipList[K, V]) Remove(searchKey K) error {
-	rn := list.Head()
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	rn := list.headNote
 	rl := list.level
 	update := make([]*SLNode[K, V], MaxLevel)
 	for rl > 0 {
@@ -140,7 +225,7 @@ func (list *SkipList[K, V]) Remove(searchKey K) error {
 			}
 			update[i].forwards[i] = rn.forwards[i]
 		}
-		for list.level > 1 && list.Head().forwards[list.level-1] == nil {
+		for list.level > 1 && list.headNote.forwards[list.level-1] == nil {
 			list.level--
 		}
 	} else {
@@ -152,20 +237,25 @@ func (list *SkipList[K, V]) Remove(searchKey K) error {
 }
 
 func (list *SkipList[K, V]) Clear() {
-	newList, err := InitSkipList[K, V]()
-	if err != nil {
+	if list == nil {
 		panic(ErrMalformedList)
 	}
 
-	list.level = newList.level
-	list.length = newList.length
-	list.headNote = newList.headNote
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	list.level = DefaultLevel
+	list.length = 0
+	list.headNote = &SLNode[K, V]{forwards: make([]*SLNode[K, V], DefaultLevel), mu: list.mu}
 }
 
 func (list *SkipList[K, V]) Len() uint {
 	if list == nil {
 		panic(ErrMalformedList)
 	}
+
+	list.mu.RLock()
+	defer list.mu.RUnlock()
 	return list.length
 }
 
@@ -2,6 +2,7 @@ package rindb
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -285,3 +286,65 @@ func keyShouldNotExists[K, V Comparable](t *testing.T, key K, list *SkipList[K,
 		r = r.Next()
 	}
 }
+
+func TestSkipListSeek(t *testing.T) {
+	list, err := InitSkipList[int, int]()
+	assert.NoError(t, err)
+	assert.Nil(t, list.Seek(1))
+
+	for _, v := range []int{10, 30, 20} {
+		list.Put(v, v)
+	}
+
+	assert.Equal(t, 10, list.Seek(0).Key)
+	assert.Equal(t, 20, list.Seek(20).Key)
+	assert.Equal(t, 30, list.Seek(21).Key)
+	assert.Nil(t, list.Seek(31))
+}
+
+func TestSkipListSeekBefore(t *testing.T) {
+	list, err := InitSkipList[int, int]()
+	assert.NoError(t, err)
+	assert.Nil(t, list.SeekBefore(1))
+	assert.Nil(t, list.Last())
+
+	for _, v := range []int{10, 30, 20} {
+		list.Put(v, v)
+	}
+
+	assert.Nil(t, list.SeekBefore(10))
+	assert.Equal(t, 10, list.SeekBefore(20).Key)
+	assert.Equal(t, 20, list.SeekBefore(21).Key)
+	assert.Equal(t, 30, list.SeekBefore(31).Key)
+	assert.Equal(t, 30, list.Last().Key)
+}
+
+func TestSkipListConcurrency(t *testing.T) {
+	list, err := InitSkipList[int, int]()
+	assert.NoError(t, err)
+
+	const writers, puts = 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < puts; i++ {
+				list.Put(i*writers+w+1, w)
+			}
+		}(w)
+
+		// readers walk the list while it grows
+		go func() {
+			defer wg.Done()
+			for i := 0; i < puts; i++ {
+				_, _ = list.Get(i)
+				assertOrderedList(t, list.Head())
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint(writers*puts), list.Len())
+	assertOrderedList(t, list.Head())
+}
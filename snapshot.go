@@ -0,0 +1,77 @@
+package rindb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Snapshot is a frozen view of a database, writes done after it was taken
+// are not visible through it. It must be released by DB.ReleaseSnapshot so
+// compactions can drop the versions it keeps.
+type Snapshot struct {
+	db      *DB
+	seq     uint64
+	element *list.Element
+}
+
+// Get returns the value key had when the snapshot was taken
+func (s *Snapshot) Get(key Bytes) (Bytes, error) {
+	return s.db.rin.get(key, s.seq, ReadOptions{})
+}
+
+// snapshotList holds the live snapshots ordered by sequence number
+type snapshotList struct {
+	mu        sync.Mutex
+	snapshots *list.List
+}
+
+func newSnapshotList() *snapshotList {
+	return &snapshotList{snapshots: list.New()}
+}
+
+// oldest returns the sequence number of the oldest live snapshot,
+// MaxSequence when there is none
+func (l *snapshotList) oldest() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	front := l.snapshots.Front()
+	if front == nil {
+		return MaxSequence
+	}
+	return front.Value.(*Snapshot).seq
+}
+
+func (l *snapshotList) push(snapshot *Snapshot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	snapshot.element = l.snapshots.PushBack(snapshot)
+}
+
+func (l *snapshotList) remove(snapshot *Snapshot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if snapshot.element != nil {
+		l.snapshots.Remove(snapshot.element)
+		snapshot.element = nil
+	}
+}
+
+// GetSnapshot returns a snapshot of the current state of the database
+func (db *DB) GetSnapshot() *Snapshot {
+	// no write becomes visible before the snapshot is pushed,
+	// so compactions keep what it sees
+	db.rin.mu.RLock()
+	defer db.rin.mu.RUnlock()
+
+	snapshot := &Snapshot{db: db, seq: db.rin.lastSeq}
+	db.hino.snapshots.push(snapshot)
+	return snapshot
+}
+
+// ReleaseSnapshot releases the snapshot, releasing it twice is a no-op
+func (db *DB) ReleaseSnapshot(snapshot *Snapshot) {
+	db.hino.snapshots.remove(snapshot)
+}
@@ -0,0 +1,75 @@
+package rindb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("writes after the snapshot are not visible", func(t *testing.T) {
+		db, err := Open(t.TempDir(), &Options{NoSync: true})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		assert.NoError(t, db.Put(Bytes("a"), Bytes("old")))
+		assert.NoError(t, db.Put(Bytes("b"), Bytes("b")))
+		snapshot := db.GetSnapshot()
+		defer db.ReleaseSnapshot(snapshot)
+
+		assert.NoError(t, db.Put(Bytes("a"), Bytes("new")))
+		assert.NoError(t, db.Remove(Bytes("b")))
+		assert.NoError(t, db.Put(Bytes("c"), Bytes("c")))
+
+		value, err := snapshot.Get(Bytes("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("old"), value)
+		value, err = snapshot.Get(Bytes("b"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("b"), value)
+		_, err = snapshot.Get(Bytes("c"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+
+		value, err = db.Get(Bytes("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("new"), value)
+		_, err = db.Get(Bytes("b"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	})
+
+	t.Run("versions seen by a snapshot survive flush and compaction", func(t *testing.T) {
+		db, err := Open(t.TempDir(), &Options{MemtableSize: 64, LevelFileThreshold: 1, NoSync: true})
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, db.Close()) }()
+
+		assert.NoError(t, db.Put(Bytes("a"), Bytes("old")))
+		assert.NoError(t, db.Put(Bytes("b"), Bytes("b")))
+		snapshot := db.GetSnapshot()
+
+		assert.NoError(t, db.Put(Bytes("a"), Bytes("new")))
+		assert.NoError(t, db.Remove(Bytes("b")))
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, db.Put(Bytes(fmt.Sprintf("key%02d", i)), Bytes("value")))
+		}
+		db.rin.flushes.Wait()
+		assert.NoError(t, db.hino.Compact())
+
+		value, err := snapshot.Get(Bytes("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("old"), value)
+		value, err = snapshot.Get(Bytes("b"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("b"), value)
+
+		value, err = db.Get(Bytes("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("new"), value)
+		_, err = db.Get(Bytes("b"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+
+		db.ReleaseSnapshot(snapshot)
+		db.ReleaseSnapshot(snapshot)
+		assert.Equal(t, MaxSequence, db.hino.snapshots.oldest())
+	})
+}
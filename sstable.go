@@ -1,164 +1,516 @@
 package rindb
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
 	"os"
+	"sort"
 
 	"github.com/pkg/errors"
 )
 
-type (
-	KeyOffset struct {
-		key    Bytes
-		offset int64
-	}
+// A sstable is made of data blocks holding the records ordered by their
+// internal keys, a metaindex block, an index block with the last key and
+// the handle of every data block, then a fixed size footer:
+//
+//	[data block] ... [metaindex block] [index block] [footer]
+//
+// Every block is followed by a trailer made of its compression type
+// as 1 byte and the CRC32C of the stored block and that type as a 4 bytes
+// number, block handles do not count it. Only data blocks are compressed.
+//
+// The metaindex block maps the names of the meta blocks to their handles,
+// filterBlockName is the bloom filter of the user keys. The footer holds
+// the handles of the metaindex and the index blocks as 8 bytes numbers,
+// the format version and the magic number.
+
+const (
+	tableMagic         = uint64(0x74737362646e6972) // "rindbsst"
+	tableFormatVersion = uint32(3)
+
+	formatVersionSize = 4
+	blockTrailerSize  = 5
+	footerSize        = 4*mdByteSize + formatVersionSize + mdByteSize
+
+	defaultBlockSize            = 4 << 10
+	defaultBlockRestartInterval = 16
+	defaultBloomFalsePositive   = 0.01
+
+	filterBlockName = "filter.bloom"
+)
 
-	SparseIndex []KeyOffset
+var (
+	ErrMalFormedSSTable = errors.New("malformed sstable")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
 )
 
-var _ Record = KeyOffset{}
+// CorruptionError locates a corrupted block of a sstable
+type CorruptionError struct {
+	File   string
+	Offset uint64
+	Err    error
+}
 
-func NewKeyOffset(key, offset Bytes) KeyOffset {
-	return KeyOffset{
-		key:    key,
-		offset: int64(byteOrder.Uint64(offset)),
-	}
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s: block at offset %d: %v", e.File, e.Offset, e.Err)
 }
 
-// GetSize implements Record.
-func (k KeyOffset) GetSize() int {
-	return len(k.GetKey()) + mdByteSize
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
 }
 
-// GetKey implements Record.
-func (k KeyOffset) GetKey() Bytes {
-	return k.key
+// blockHandle locates a block in a sstable
+type blockHandle struct {
+	offset, size uint64
 }
 
-// GetValue implements Record.
-func (k KeyOffset) GetValue() Bytes {
-	valueLenBytes := make(Bytes, mdByteSize)
-	byteOrder.PutUint64(valueLenBytes, uint64(k.offset))
-	return valueLenBytes
+func (h blockHandle) encode() []byte {
+	encoded := make([]byte, 0, 2*binary.MaxVarintLen64)
+	encoded = binary.AppendUvarint(encoded, h.offset)
+	return binary.AppendUvarint(encoded, h.size)
 }
 
-func (s SparseIndex) GetOffset(key Bytes) (int64, error) {
-	headIdx := 0
-	tailIdx := len(s) - 1
+func decodeBlockHandle(encoded []byte) (blockHandle, error) {
+	offset, n := binary.Uvarint(encoded)
+	if n <= 0 {
+		return blockHandle{}, ErrMalFormedSSTable
+	}
+	size, m := binary.Uvarint(encoded[n:])
+	if m <= 0 {
+		return blockHandle{}, ErrMalFormedSSTable
+	}
+	return blockHandle{offset: offset, size: size}, nil
+}
 
-	for {
-		if Compare(key, s[headIdx].key) == CmpEqual {
-			return s[headIdx].offset, nil
+// readBlockContents reads the block of handle, its checksum is checked
+// with verify
+func readBlockContents(file *os.File, handle blockHandle, verify bool) ([]byte, error) {
+	data := make([]byte, handle.size+blockTrailerSize)
+	if _, err := file.ReadAt(data, int64(handle.offset)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = ErrMalFormedSSTable
 		}
+		return nil, &CorruptionError{File: file.Name(), Offset: handle.offset, Err: err}
+	}
 
-		if Compare(key, s[tailIdx].key) == CmpEqual {
-			return s[tailIdx].offset, nil
-		}
+	if verify && crc32.Checksum(data[:handle.size+1], crc32c) != byteOrder.Uint32(data[handle.size+1:]) {
+		return nil, &CorruptionError{File: file.Name(), Offset: handle.offset, Err: ErrChecksumMismatch}
+	}
 
-		const half = 2
-		midIdx := (headIdx + tailIdx) / half
-		if headIdx == midIdx || tailIdx == midIdx {
-			break
-		}
+	contents, err := decompressBlock(data[:handle.size], CompressionType(data[handle.size]))
+	if err != nil {
+		return nil, &CorruptionError{File: file.Name(), Offset: handle.offset, Err: err}
+	}
+	return contents, nil
+}
 
-		if Compare(key, s[midIdx].key) == CmpLess {
-			tailIdx = midIdx
-		} else {
-			headIdx = midIdx
-		}
+func readBlock(file *os.File, handle blockHandle, verify bool) (*block, error) {
+	data, err := readBlockContents(file, handle, verify)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := decodeBlock(data)
+	if err != nil {
+		return nil, &CorruptionError{File: file.Name(), Offset: handle.offset, Err: err}
 	}
+	return block, nil
+}
 
-	return 0, ErrKeyNotFound
+// indexEntry is the last key and the handle of a data block
+type indexEntry struct {
+	lastKey InternalKey
+	handle  blockHandle
 }
 
-var ErrMalFormedSSTable = errors.New("malformed sstable")
+// tableIndex is the part of a sstable kept in memory
+type tableIndex struct {
+	blocks            []indexEntry
+	smallest, largest Bytes
 
-type SStable struct {
-	*FileSystem
-	SparseIndex SparseIndex
+	// filter holds the user keys of the sstable, nil when it has none
+	filter *BloomFilter
 }
 
-func (s SStable) GetValue(key Bytes) (Bytes, error) {
-	offset, err := s.SparseIndex.GetOffset(key)
+// mayContain reports whether key may be stored in the sstable
+func (t tableIndex) mayContain(key Bytes) bool {
+	return t.filter == nil || t.filter.Lookup(key)
+}
+
+// readTableIndex reads the footer and the index block of the sstable
+// of size bytes stored in file, their checksums are always checked
+func readTableIndex(file *os.File, size int64) (tableIndex, error) {
+	if size < footerSize {
+		return tableIndex{}, ErrMalFormedSSTable
+	}
+
+	footerOffset := uint64(size - footerSize)
+	footer := make([]byte, footerSize)
+	if _, err := file.ReadAt(footer, int64(footerOffset)); err != nil {
+		return tableIndex{}, errors.Wrap(err, "failed to read footer")
+	}
+	if byteOrder.Uint64(footer[footerSize-mdByteSize:]) != tableMagic {
+		return tableIndex{}, &CorruptionError{File: file.Name(), Offset: footerOffset,
+			Err: errors.Wrap(ErrMalFormedSSTable, "bad magic number")}
+	}
+	if version := byteOrder.Uint32(footer[4*mdByteSize:]); version != tableFormatVersion {
+		return tableIndex{}, errors.Wrapf(ErrMalFormedSSTable, "unsupported format version %d", version)
+	}
+
+	metaindexHandle := blockHandle{
+		offset: byteOrder.Uint64(footer),
+		size:   byteOrder.Uint64(footer[mdByteSize:]),
+	}
+	filter, err := readFilter(file, metaindexHandle)
 	if err != nil {
-		return nil, err
+		return tableIndex{}, err
 	}
 
-	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
-		return nil, errors.Wrap(err, "failed to seek to offset")
+	indexHandle := blockHandle{
+		offset: byteOrder.Uint64(footer[2*mdByteSize:]),
+		size:   byteOrder.Uint64(footer[3*mdByteSize:]),
+	}
+	indexBlock, err := readBlock(file, indexHandle, true)
+	if err != nil {
+		return tableIndex{}, errors.Wrap(err, "failed to read index block")
 	}
 
-	record, err := ReadRecord(s)
+	index := tableIndex{filter: filter}
+	iterator := indexBlock.newIterator(compareEncodedKeys)
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
+		handle, err := decodeBlockHandle(iterator.Value())
+		if err != nil {
+			return tableIndex{}, errors.Wrap(err, "bad block handle")
+		}
+		index.blocks = append(index.blocks, indexEntry{decodeInternalKey(iterator.Key()), handle})
+	}
+	if err := iterator.Error(); err != nil {
+		return tableIndex{}, errors.Wrap(err, "failed to read index block")
+	}
+	if len(index.blocks) == 0 {
+		return tableIndex{}, errors.Wrap(ErrMalFormedSSTable, "no data block")
+	}
+
+	firstBlock, err := readBlock(file, index.blocks[0].handle, true)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read record")
+		return tableIndex{}, err
 	}
-	return record.GetValue(), nil
+	first := firstBlock.newIterator(compareEncodedKeys)
+	first.SeekToFirst()
+	if !first.Valid() {
+		return tableIndex{}, errors.Wrap(ErrMalFormedSSTable, "empty data block")
+	}
+	index.smallest = decodeInternalKey(first.Key()).UserKey
+	index.largest = index.blocks[len(index.blocks)-1].lastKey.UserKey
+	return index, nil
 }
 
-func NewSSTable(fs *FileSystem) (SStable, error) {
-	fileInfo, err := os.Stat(fs.Path())
+// readFilter reads the bloom filter listed by the metaindex block,
+// nil when there is none
+func readFilter(file *os.File, metaindexHandle blockHandle) (*BloomFilter, error) {
+	metaindex, err := readBlock(file, metaindexHandle, true)
 	if err != nil {
-		return SStable{}, errors.Wrap(err, "failed to load file info")
+		return nil, errors.Wrap(err, "failed to read metaindex block")
+	}
+
+	iterator := metaindex.newIterator(bytes.Compare)
+	iterator.Seek([]byte(filterBlockName))
+	if err := iterator.Error(); err != nil {
+		return nil, errors.Wrap(err, "failed to read metaindex block")
 	}
-	if fileInfo.Size() < mdByteSize {
-		ERROR("Failed to load file %s: %v", fs.Path(), ErrMalFormedSSTable)
-		return SStable{}, ErrMalFormedSSTable
+	if !iterator.Valid() || string(iterator.Key()) != filterBlockName {
+		return nil, nil
 	}
 
-	sparseIndex, err := loadSparseIndex(fs)
+	handle, err := decodeBlockHandle(iterator.Value())
 	if err != nil {
-		return SStable{}, errors.Wrap(err, "failed to load sparse index")
+		return nil, errors.Wrap(err, "bad filter block handle")
+	}
+	data, err := readBlockContents(file, handle, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read filter block")
+	}
+
+	filter := &BloomFilter{}
+	if err := filter.UnmarshalBinary(data); err != nil {
+		return nil, errors.Wrap(err, "failed to load filter block")
 	}
-	return SStable{fs, sparseIndex}, nil
+	return filter, nil
 }
 
-func readTailSSTable(fs *FileSystem) (int64, error) {
-	tailSSTableOffset, err := fs.file.Seek(-1*mdByteSize, io.SeekEnd)
-	if err != nil {
-		return 0, err
+// tableOptions configures the layout of written sstables
+type tableOptions struct {
+	blockSize       int
+	restartInterval int
+
+	// bloomFalsePositive is the false positive rate of the bloom
+	// filter, no filter is written when it is zero
+	bloomFalsePositive float64
+
+	// compression is the codec of the data blocks
+	compression CompressionType
+}
+
+func defaultTableOptions() tableOptions {
+	return tableOptions{
+		blockSize:          defaultBlockSize,
+		restartInterval:    defaultBlockRestartInterval,
+		bloomFalsePositive: defaultBloomFalsePositive,
 	}
-	return tailSSTableOffset, nil
 }
 
-func loadSparseIndex(fs *FileSystem) (SparseIndex, error) {
-	tailSSTableOffset, err := readTailSSTable(fs)
-	if err != nil {
-		return SparseIndex{}, errors.Wrap(err, "failed to seek tail of sstable")
+// tableBuilder writes records given in order as a sstable
+type tableBuilder struct {
+	w      io.Writer
+	opts   tableOptions
+	offset uint64
+
+	data  *blockBuilder
+	index *blockBuilder
+
+	// lastKey is the last key added, the key of the pending index entry
+	lastKey []byte
+	tableIndex
+
+	// filterKeys are the user keys added, put into the filter on finish
+	filterKeys []Bytes
+}
+
+func newTableBuilder(w io.Writer, opts tableOptions) *tableBuilder {
+	return &tableBuilder{
+		w:     w,
+		opts:  opts,
+		data:  newBlockBuilder(opts.restartInterval),
+		index: newBlockBuilder(1),
 	}
+}
 
-	sparseIndexOffset, err := ReadNumber(fs)
-	if err != nil {
-		return SparseIndex{}, errors.Wrap(err, "failed to read offset sparse index")
+func (b *tableBuilder) add(record Record) error {
+	key := internalKeyOf(record).encode()
+	if b.smallest == nil {
+		b.smallest = record.GetKey()
+	}
+	if len(b.filterKeys) == 0 || Compare(b.largest, record.GetKey()) != CmpEqual {
+		b.filterKeys = append(b.filterKeys, record.GetKey())
 	}
+	b.largest = record.GetKey()
 
-	ret, err := fs.file.Seek(int64(sparseIndexOffset), io.SeekStart)
+	b.data.add(key, record.GetValue())
+	b.lastKey = key
+	if b.data.estimatedSize() >= b.opts.blockSize {
+		return b.flushBlock()
+	}
+	return nil
+}
+
+func (b *tableBuilder) flushBlock() error {
+	if b.data.empty() {
+		return nil
+	}
+
+	data, compression, err := compressBlock(b.data.finish(), b.opts.compression)
+	if err != nil {
+		return err
+	}
+	handle, err := b.writeBlock(data, compression)
 	if err != nil {
-		return SparseIndex{}, errors.Wrap(err, "failed to seek offset of sparse index")
+		return err
 	}
+	b.index.add(b.lastKey, handle.encode())
+	b.blocks = append(b.blocks, indexEntry{decodeInternalKey(b.lastKey), handle})
+	return nil
+}
 
-	sparseIndex := SparseIndex{}
-	for {
-		if !(ret < tailSSTableOffset) {
-			break
+func (b *tableBuilder) writeBlock(data []byte, compression CompressionType) (blockHandle, error) {
+	handle := blockHandle{offset: b.offset, size: uint64(len(data))}
+	trailer := make([]byte, blockTrailerSize)
+	trailer[0] = byte(compression)
+	checksum := crc32.Update(crc32.Checksum(data, crc32c), crc32c, trailer[:1])
+	byteOrder.PutUint32(trailer[1:], checksum)
+	for _, part := range [][]byte{data, trailer} {
+		if _, err := b.w.Write(part); err != nil {
+			return blockHandle{}, errors.Wrap(err, "failed to write block")
 		}
+	}
+	b.offset += handle.size + blockTrailerSize
+	return handle, nil
+}
+
+// finish writes the pending data block, the metaindex and index blocks
+// then the footer
+func (b *tableBuilder) finish() (tableIndex, error) {
+	if err := b.flushBlock(); err != nil {
+		return tableIndex{}, err
+	}
 
-		record, err := ReadRecord(fs)
+	metaindex := newBlockBuilder(1)
+	if b.opts.bloomFalsePositive > 0 && len(b.filterKeys) > 0 {
+		b.filter = newTableFilter(b.filterKeys, b.opts.bloomFalsePositive)
+		data, err := b.filter.MarshalBinary()
 		if err != nil {
-			return SparseIndex{}, errors.Wrap(err, "failed to read record")
+			return tableIndex{}, err
 		}
-		sparseIndex = append(sparseIndex, NewKeyOffset(record.GetKey(), record.GetValue()))
-
-		ret, err = fs.CursorPos()
+		handle, err := b.writeBlock(data, NoCompression)
 		if err != nil {
-			return SparseIndex{}, errors.Wrap(err, "failed to read current cursor position")
+			return tableIndex{}, errors.Wrap(err, "failed to write filter block")
+		}
+		metaindex.add([]byte(filterBlockName), handle.encode())
+	}
+
+	metaindexHandle, err := b.writeBlock(metaindex.finish(), NoCompression)
+	if err != nil {
+		return tableIndex{}, errors.Wrap(err, "failed to write metaindex block")
+	}
+	indexHandle, err := b.writeBlock(b.index.finish(), NoCompression)
+	if err != nil {
+		return tableIndex{}, errors.Wrap(err, "failed to write index block")
+	}
+
+	footer := make([]byte, footerSize)
+	byteOrder.PutUint64(footer, metaindexHandle.offset)
+	byteOrder.PutUint64(footer[mdByteSize:], metaindexHandle.size)
+	byteOrder.PutUint64(footer[2*mdByteSize:], indexHandle.offset)
+	byteOrder.PutUint64(footer[3*mdByteSize:], indexHandle.size)
+	byteOrder.PutUint32(footer[4*mdByteSize:], tableFormatVersion)
+	byteOrder.PutUint64(footer[footerSize-mdByteSize:], tableMagic)
+	if _, err := b.w.Write(footer); err != nil {
+		return tableIndex{}, errors.Wrap(err, "failed to write footer")
+	}
+	return b.tableIndex, nil
+}
+
+// newTableFilter returns a bloom filter holding keys
+func newTableFilter(keys []Bytes, falsePositive float64) *BloomFilter {
+	filter := NewBloomFilter(
+		SetN(uint64(len(keys))),
+		SetP(falsePositive),
+		WithCalculatedM(),
+		WithCalculatedK(),
+		func(cfg *bloomFilterConfig) {
+			cfg.m = max(cfg.m, 1)
+			cfg.k = max(cfg.k, 1)
+		},
+	)
+	for _, key := range keys {
+		filter.Insert(key)
+	}
+	return filter
+}
+
+// SStable stores records ordered by their internal keys, every version of
+// a key follows its newest one. Only its index is held in memory, the
+// data blocks are read on demand.
+type SStable struct {
+	*FileSystem
+	tableIndex
+}
+
+func (s SStable) GetValue(key Bytes) (Bytes, error) {
+	record, err := s.lookup(key, MaxSequence, true)
+	if err != nil {
+		return nil, err
+	}
+	if IsTombstone(record) {
+		return nil, ErrKeyNotFound
+	}
+	return record.GetValue(), nil
+}
+
+// lookup returns the newest version of key whose sequence number is not
+// greater than seq, it may be a tombstone. Keys rejected by the bloom
+// filter are not read, otherwise the data block which may hold the key
+// is found by binary search over the index. Its checksum is checked
+// with verify.
+func (s SStable) lookup(key Bytes, seq uint64, verify bool) (Record, error) {
+	if !s.mayContain(key) {
+		return nil, ErrKeyNotFound
+	}
+
+	iterator := s.newIterator()
+	iterator.verify = verify
+	iterator.Seek(InternalKey{UserKey: key, Seq: seq, Type: RecordTypeDelete})
+	if err := iterator.Error(); err != nil {
+		return nil, err
+	}
+	if !iterator.Valid() || Compare(iterator.Key().UserKey, key) != CmpEqual {
+		return nil, ErrKeyNotFound
+	}
+	return iterator.record(), nil
+}
+
+// KeyRange returns the smallest and the largest key of the sstable
+func (s SStable) KeyRange() (smallest, largest Bytes) {
+	return s.smallest, s.largest
+}
+
+// userKeys returns the keys of [start, end) stored in the sstable
+func (s SStable) userKeys(start, end Bytes) ([]Bytes, error) {
+	keys := make([]Bytes, 0)
+	iterator := s.newIterator()
+	iterator.Seek(InternalKey{UserKey: start, Seq: MaxSequence, Type: RecordTypeDelete})
+	for ; iterator.Valid() && Compare(iterator.Key().UserKey, end) == CmpLess; iterator.Next() {
+		key := iterator.Key().UserKey
+		if len(keys) == 0 || Compare(keys[len(keys)-1], key) != CmpEqual {
+			keys = append(keys, key)
+		}
+	}
+	return keys, iterator.Error()
+}
+
+// Verify reads every block of the sstable, the returned error is
+// a *CorruptionError locating the first corrupted block
+func (s SStable) Verify() error {
+	fileInfo, err := s.file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to load file info")
+	}
+
+	index, err := readTableIndex(s.file, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+	for _, entry := range index.blocks {
+		if _, err := readBlock(s.file, entry.handle, true); err != nil {
+			return err
 		}
 	}
-	return sparseIndex, nil
+	return nil
+}
+
+func NewSSTable(fs *FileSystem) (SStable, error) {
+	fileInfo, err := os.Stat(fs.Path())
+	if err != nil {
+		return SStable{}, errors.Wrap(err, "failed to load file info")
+	}
+
+	index, err := readTableIndex(fs.file, fileInfo.Size())
+	if err != nil {
+		ERROR("Failed to load file %s: %v", fs.Path(), err)
+		return SStable{}, errors.Wrap(err, "failed to load index")
+	}
+	return SStable{fs, index}, nil
 }
 
 func Flush(mem Memtable, fs *FileSystem) (SStable, error) {
+	sstable, err := writeSSTable(mem, fs, defaultTableOptions())
+	if err != nil {
+		return SStable{}, err
+	}
+
+	// after flushing memtable to file system successfully.
+	// memtable is supposed to be purged
+	mem.Clear()
+
+	return sstable, nil
+}
+
+// writeSSTable is Flush without purging the memtable
+func writeSSTable(mem Memtable, fs *FileSystem, opts tableOptions) (SStable, error) {
 	if mem.data.Len() == 0 {
 		WARN("Flushing empty memtable!")
 		log.Panic("empty memtable!")
@@ -167,30 +519,17 @@ func Flush(mem Memtable, fs *FileSystem) (SStable, error) {
 	// txBuf is a buffer for making sure that once
 	// content wrote to a disk it must be full content
 	txBuf := bytes.NewBufferString("")
+	builder := newTableBuilder(txBuf, opts)
 
-	r := mem.data.Head().Next()
-	for r != nil {
-		err := WriteRecord(txBuf, RecordImpl{r.Key, r.Value})
-		if err != nil {
+	for r := mem.data.Head().Next(); r != nil; r = r.Next() {
+		if err := builder.add(toRecord(r)); err != nil {
 			return SStable{}, errors.Wrap(err, "failed to write record to sstable")
 		}
-
-		r = r.Next()
 	}
 
-	// this sparseIndexOffset is standing for
-	// end of data and offset sparse index
-	sparseIndexOffset := uint64(txBuf.Len())
-
-	sparseIndex := genSparseIndex(mem)
-	for _, v := range sparseIndex {
-		if err := WriteRecord(txBuf, v); err != nil {
-			return SStable{}, errors.Wrap(err, "failed to write index to sstable")
-		}
-	}
-
-	if err := WriteNumber(txBuf, sparseIndexOffset); err != nil {
-		return SStable{}, errors.Wrap(err, "failed to write offset index to sstable")
+	index, err := builder.finish()
+	if err != nil {
+		return SStable{}, errors.Wrap(err, "failed to finish sstable")
 	}
 
 	if _, err := fs.Write(txBuf.Bytes()); err != nil {
@@ -201,61 +540,281 @@ func Flush(mem Memtable, fs *FileSystem) (SStable, error) {
 		return SStable{}, errors.Wrap(err, "failed to sync file system")
 	}
 
-	// after flushing memtable to file system successfully.
-	// memtable is supposed to be purged
-	mem.Clear()
+	return SStable{fs, index}, nil
+}
 
-	return SStable{fs, sparseIndex}, nil
+// sstableWriter writes a sstable record by record, only the pending data
+// block and the index are held in memory
+type sstableWriter struct {
+	fs      *FileSystem
+	buf     *bufio.Writer
+	builder *tableBuilder
 }
 
-func genSparseIndex(mem Memtable) SparseIndex {
-	sparseIndex := make(SparseIndex, 0, mem.data.Len())
+func newSSTableWriter(fs *FileSystem, opts tableOptions) *sstableWriter {
+	buf := bufio.NewWriter(fs)
+	return &sstableWriter{fs: fs, buf: buf, builder: newTableBuilder(buf, opts)}
+}
 
-	cursor := int64(0)
-	runNode := mem.data.Head().Next()
-	for runNode != nil {
-		sparseIndex = append(sparseIndex, KeyOffset{runNode.Key, cursor})
-		cursor += int64(CalOnDiskSize(toRecord(runNode)))
-		runNode = runNode.Next()
+func (w *sstableWriter) add(record Record) error {
+	if err := w.builder.add(record); err != nil {
+		return errors.Wrap(err, "failed to write record to sstable")
 	}
-	return sparseIndex
+	return nil
+}
+
+// estimatedSize is the size of the records written so far
+func (w *sstableWriter) estimatedSize() int {
+	return int(w.builder.offset) + w.builder.data.estimatedSize()
+}
+
+// finish writes the end of the sstable and syncs it
+func (w *sstableWriter) finish() (SStable, error) {
+	index, err := w.builder.finish()
+	if err != nil {
+		return SStable{}, errors.Wrap(err, "failed to finish sstable")
+	}
+	if err := w.buf.Flush(); err != nil {
+		return SStable{}, errors.Wrap(err, "failed to write sstable")
+	}
+	if err := w.fs.Sync(); err != nil {
+		return SStable{}, errors.Wrap(err, "failed to sync file system")
+	}
+	return SStable{w.fs, index}, nil
 }
 
 var _ Iterator[Record] = (*sstableIterator)(nil)
 
 type sstableIterator struct {
-	*FileSystem
-	currentIdx int
-	maxIdx     int
+	*tableIterator
 }
 
 // HasNext implements Iterator.
 func (s *sstableIterator) HasNext() bool {
-	return s.currentIdx < s.maxIdx
+	return s.Valid() || s.Error() != nil
 }
 
 // Next implements Iterator.
 func (s *sstableIterator) Next() (Record, error) {
-	if s.HasNext() {
-		record, err := ReadRecord(s)
+	if err := s.Error(); err != nil {
+		return nil, err
+	}
+	if !s.Valid() {
+		return nil, EOI
+	}
+
+	record := s.record()
+	s.tableIterator.Next()
+	return record, nil
+}
+
+func (s SStable) Iterator() (Iterator[Record], error) {
+	iterator := s.newIterator()
+	iterator.SeekToFirst()
+	return &sstableIterator{iterator}, nil
+}
+
+// newIterator returns an iterator reading through the file of the sstable
+func (s SStable) newIterator() *tableIterator {
+	index := s.tableIndex
+	return &tableIterator{file: s.file, index: &index, verify: true}
+}
+
+var _ internalIterator = (*tableIterator)(nil)
+
+// tableIterator walks every version held by a sstable, one data block is
+// loaded at a time. An iterator owning its file opens it and loads the
+// index on first use, nothing is read while the key range of the sstable
+// is out of bounds.
+type tableIterator struct {
+	file              *os.File
+	filePath          string
+	owned             bool
+	smallest, largest Bytes
+	bounds            *iterBounds
+
+	// release is called once the owned file is closed
+	release func()
+
+	// verify checks the checksums of the data blocks
+	verify bool
+
+	// index is nil until loaded
+	index *tableIndex
+
+	blockIdx int
+	block    *blockIterator
+	err      error
+}
+
+// newTableIterator reads the records of the sstable at filePath holding the
+// keys of [smallest, largest], the file is opened once they are in bounds.
+// release is called on Close, it may be nil.
+func newTableIterator(filePath string, smallest, largest Bytes, bounds *iterBounds, verify bool, release func()) *tableIterator {
+	return &tableIterator{
+		filePath: filePath,
+		owned:    true,
+		smallest: smallest,
+		largest:  largest,
+		bounds:   bounds,
+		verify:   verify,
+		release:  release,
+	}
+}
+
+// open loads the index unless the sstable is out of bounds, it reports
+// whether the sstable can be read
+func (t *tableIterator) open() bool {
+	t.block = nil
+	if t.err != nil || (t.bounds != nil && !t.bounds.overlaps(t.smallest, t.largest)) {
+		return false
+	}
+	if t.index != nil {
+		return true
+	}
+
+	if t.file == nil {
+		file, err := os.Open(t.filePath)
 		if err != nil {
-			return nil, err
+			t.err = errors.Wrapf(err, "failed to open sstable %s", t.filePath)
+			return false
 		}
-		s.currentIdx += 1
-		return record, nil
+		t.file = file
+	}
+	fileInfo, err := t.file.Stat()
+	if err != nil {
+		t.err = errors.Wrapf(err, "failed to load file info of %s", t.file.Name())
+		return false
 	}
-	return nil, EOI
+	index, err := readTableIndex(t.file, fileInfo.Size())
+	if err != nil {
+		t.err = errors.Wrapf(err, "failed to load index of %s", t.file.Name())
+		return false
+	}
+	t.index = &index
+	return true
 }
 
-func (s SStable) Iterator() (Iterator[Record], error) {
-	_, err := s.FileSystem.file.Seek(0, io.SeekStart)
+// loadBlock reads the data block at idx of the index
+func (t *tableIterator) loadBlock(idx int) bool {
+	t.blockIdx = idx
+	t.block = nil
+	if idx < 0 || idx >= len(t.index.blocks) {
+		return false
+	}
+
+	block, err := readBlock(t.file, t.index.blocks[idx].handle, t.verify)
 	if err != nil {
-		return nil, err
+		t.err = err
+		return false
 	}
+	t.block = block.newIterator(compareEncodedKeys)
+	return true
+}
 
-	return &sstableIterator{
-		currentIdx: 0,
-		maxIdx:     len(s.SparseIndex),
-		FileSystem: s.FileSystem,
-	}, nil
+// exhausted reports whether the current block is over without error
+func (t *tableIterator) exhausted() bool {
+	if t.block == nil || t.block.Valid() {
+		return false
+	}
+	if err := t.block.Error(); err != nil {
+		t.err = errors.Wrapf(err, "failed to read %s", t.file.Name())
+		return false
+	}
+	return true
+}
+
+// Valid implements internalIterator.
+func (t *tableIterator) Valid() bool {
+	return t.err == nil && t.block != nil && t.block.Valid()
+}
+
+// SeekToFirst implements internalIterator.
+func (t *tableIterator) SeekToFirst() {
+	if t.open() && t.loadBlock(0) {
+		t.block.SeekToFirst()
+	}
+}
+
+// SeekToLast implements internalIterator.
+func (t *tableIterator) SeekToLast() {
+	if t.open() && t.loadBlock(len(t.index.blocks)-1) {
+		t.block.SeekToLast()
+	}
+}
+
+// Seek implements internalIterator.
+func (t *tableIterator) Seek(key InternalKey) {
+	if !t.open() {
+		return
+	}
+
+	blocks := t.index.blocks
+	idx := sort.Search(len(blocks), func(i int) bool {
+		return blocks[i].lastKey.Compare(key) != CmpLess
+	})
+	if !t.loadBlock(idx) {
+		return
+	}
+	t.block.Seek(key.encode())
+	if t.exhausted() && t.loadBlock(idx+1) {
+		t.block.SeekToFirst()
+	}
+}
+
+// Next implements internalIterator.
+func (t *tableIterator) Next() {
+	t.block.Next()
+	if t.exhausted() && t.loadBlock(t.blockIdx+1) {
+		t.block.SeekToFirst()
+	}
+}
+
+// Prev implements internalIterator.
+func (t *tableIterator) Prev() {
+	t.block.Prev()
+	if t.exhausted() && t.loadBlock(t.blockIdx-1) {
+		t.block.SeekToLast()
+	}
+}
+
+// Key implements internalIterator.
+func (t *tableIterator) Key() InternalKey {
+	return decodeInternalKey(t.block.Key())
+}
+
+// Value implements internalIterator.
+func (t *tableIterator) Value() Bytes {
+	return t.block.Value()
+}
+
+func (t *tableIterator) record() Record {
+	key := t.Key()
+	return RecordImpl{Key: key.UserKey, Value: t.Value(), Type: key.Type, Seq: key.Seq}
+}
+
+// Error implements internalIterator.
+func (t *tableIterator) Error() error {
+	if t.err == nil && t.block != nil && t.block.Error() != nil {
+		return errors.Wrapf(t.block.Error(), "failed to read %s", t.file.Name())
+	}
+	return t.err
+}
+
+// Close implements internalIterator.
+func (t *tableIterator) Close() error {
+	if !t.owned {
+		return nil
+	}
+
+	var err error
+	if t.file != nil {
+		err = t.file.Close()
+		t.file = nil
+	}
+	if t.release != nil {
+		t.release()
+		t.release = nil
+	}
+	return err
 }
@@ -1,7 +1,9 @@
 package rindb
 
 import (
-	"io"
+	"bytes"
+	"fmt"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,8 +31,6 @@ func TestSStable(t *testing.T) {
 	})
 
 	t.Run("Write a memtable with few elements", func(t *testing.T) {
-		var sstable SStable
-
 		fss, closer := initTempFileSystems(t, 1)
 		defer closer()
 
@@ -53,105 +53,104 @@ func TestSStable(t *testing.T) {
 
 		sstable, err := Flush(mem, fs)
 		assert.NoError(t, err)
+		assert.Len(t, sstable.blocks, 1)
 
-		tailSSTableOffset, err := readTailSSTable(sstable.FileSystem)
+		fileInfo, err := os.Stat(fs.Path())
 		assert.NoError(t, err)
-
-		sparseIndexOffset, err := ReadNumber(sstable)
+		footer := make([]byte, footerSize)
+		_, err = fs.file.ReadAt(footer, fileInfo.Size()-footerSize)
 		assert.NoError(t, err)
-		assert.NotZero(t, sparseIndexOffset)
+		assert.Equal(t, tableMagic, byteOrder.Uint64(footer[footerSize-mdByteSize:]))
+		assert.Equal(t, tableFormatVersion, byteOrder.Uint32(footer[4*mdByteSize:]))
 
-		ret, err := sstable.file.Seek(0, io.SeekStart)
+		iterator, err := sstable.Iterator()
 		assert.NoError(t, err)
-
-		expectedSparseIndex := make(SparseIndex, 0)
-		idx := 0
-		for {
-			if !(ret < int64(sparseIndexOffset)) {
-				break
-			}
-			record, err := ReadRecord(sstable)
-			assert.NoError(t, err)
-			assert.Equal(t, data[idx].key, record.GetKey())
-			assert.Equal(t, data[idx].value, record.GetValue())
-
-			expectedSparseIndex = append(expectedSparseIndex, KeyOffset{record.GetKey(), ret})
-
-			ret, err = sstable.CursorPos()
+		for _, v := range data {
+			record, err := iterator.Next()
 			assert.NoError(t, err)
-
-			idx++
+			assert.Equal(t, v.key, record.GetKey())
+			assert.Equal(t, v.value, record.GetValue())
 		}
+		assert.False(t, iterator.HasNext())
 
-		idx = 0
-		for {
-			if !(ret < tailSSTableOffset) {
-				break
-			}
-			record, err := ReadRecord(sstable)
-			assert.NoError(t, err)
-			assert.Equal(t, data[idx].key, record.GetKey())
-
-			k := NewKeyOffset(record.GetKey(), record.GetValue())
-			assert.Equal(t, expectedSparseIndex[idx].key, k.key)
-			assert.Equal(t, expectedSparseIndex[idx].offset, k.offset)
-
-			ret, err = sstable.CursorPos()
-			assert.NoError(t, err)
-
-			idx++
-		}
+		smallest, largest := sstable.KeyRange()
+		assert.Equal(t, Bytes("a"), smallest)
+		assert.Equal(t, Bytes("d"), largest)
 	})
 
-	t.Run("Loaded Sparse index should be the same with calculated index", func(t *testing.T) {
+	t.Run("Loaded index should be the same with written index", func(t *testing.T) {
 		fss, closer := initTempFileSystems(t, 1)
 		defer closer()
 
 		fs := fss[0]
 
 		mem := InitMemtable()
-		mem.Put(Bytes("2"), Bytes("3"))
-		mem.Put(Bytes("1"), Bytes("2"))
-		mem.Put(Bytes("3"), Bytes("4"))
+		for i := 0; i < 500; i++ {
+			mem.Put(Bytes(fmt.Sprintf("key%03d", i)), Bytes(fmt.Sprintf("value%03d", i)))
+		}
 
-		sstable1, err := Flush(mem, fs)
+		sstable1, err := writeSSTable(mem, fs, tableOptions{blockSize: 256, restartInterval: 4})
 		assert.NoError(t, err)
+		assert.Greater(t, len(sstable1.blocks), 1)
 
 		sstable2, err := NewSSTable(fs)
 		assert.NoError(t, err)
 
-		assert.Equal(t, sstable1.SparseIndex, sstable2.SparseIndex)
+		assert.Equal(t, sstable1.tableIndex, sstable2.tableIndex)
 	})
 
-	t.Run("Sparse index must reflect accurate offset", func(t *testing.T) {
+	t.Run("Read keys across many blocks", func(t *testing.T) {
 		fss, closer := initTempFileSystems(t, 1)
 		defer closer()
 
-		fs := fss[0]
-
 		mem := InitMemtable()
-		mem.Put(Bytes("2"), Bytes("3"))
-		mem.Put(Bytes("1"), Bytes("2"))
-		mem.Put(Bytes("3"), Bytes("4"))
+		for i := 0; i < 500; i += 2 {
+			mem.Put(Bytes(fmt.Sprintf("key%03d", i)), Bytes(fmt.Sprintf("value%03d", i)))
+		}
 
-		_, err := Flush(mem, fs)
+		sstable, err := writeSSTable(mem, fss[0], tableOptions{blockSize: 128, restartInterval: 3})
 		assert.NoError(t, err)
+		assert.Greater(t, len(sstable.blocks), 10)
+
+		for i := 0; i < 500; i++ {
+			value, err := sstable.GetValue(Bytes(fmt.Sprintf("key%03d", i)))
+			if i%2 == 0 {
+				assert.NoError(t, err)
+				assert.Equal(t, Bytes(fmt.Sprintf("value%03d", i)), value)
+			} else {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+			}
+		}
 
-		sstable, err := NewSSTable(fs)
-		assert.NoError(t, err)
+		// walk backward then forward over the block boundaries
+		iterator := sstable.newIterator()
+		iterator.SeekToLast()
+		for i := 498; i >= 0; i -= 2 {
+			assert.True(t, iterator.Valid())
+			assert.Equal(t, Bytes(fmt.Sprintf("key%03d", i)), iterator.Key().UserKey)
+			iterator.Prev()
+		}
+		assert.False(t, iterator.Valid())
 
-		sparseIndex := sstable.SparseIndex
-		for idx := len(sparseIndex) - 1; idx > -1; idx-- {
-			keyOffset := sparseIndex[idx]
+		iterator.Seek(InternalKey{UserKey: Bytes("key101"), Seq: MaxSequence})
+		for i := 102; i < 500; i += 2 {
+			assert.True(t, iterator.Valid())
+			assert.Equal(t, Bytes(fmt.Sprintf("key%03d", i)), iterator.Key().UserKey)
+			iterator.Next()
+		}
+		assert.False(t, iterator.Valid())
+		assert.NoError(t, iterator.Error())
+	})
 
-			_, err := sstable.file.Seek(keyOffset.offset, io.SeekStart)
-			assert.NoError(t, err)
+	t.Run("Reject a file which is not a sstable", func(t *testing.T) {
+		fss, closer := initTempFileSystems(t, 1)
+		defer closer()
 
-			record, err := ReadRecord(sstable)
-			assert.NoError(t, err)
+		_, err := fss[0].Write(make([]byte, 2*footerSize))
+		assert.NoError(t, err)
 
-			assert.Equal(t, keyOffset.key, record.GetKey())
-		}
+		_, err = NewSSTable(fss[0])
+		assert.ErrorIs(t, err, ErrMalFormedSSTable)
 	})
 
 	t.Run("Flush memtable to file system and return sstable", func(t *testing.T) {
@@ -232,144 +231,146 @@ func TestSStable(t *testing.T) {
 	})
 }
 
-func Test_genSparseIndex(t *testing.T) {
+func TestSStable_versions(t *testing.T) {
+	fss, closer := initTempFileSystems(t, 1)
+	defer closer()
+
 	mem := InitMemtable()
-	mem.Put(Bytes("1"), Bytes("2"))
-	mem.Put(Bytes("2"), Bytes("3"))
-	mem.Put(Bytes("3"), Bytes("4"))
+	mem.PutRecord(RecordImpl{Key: Bytes("a"), Value: Bytes("old"), Seq: 1})
+	mem.PutRecord(RecordImpl{Key: Bytes("b"), Value: Bytes("b"), Seq: 2})
+	mem.PutRecord(RecordImpl{Key: Bytes("a"), Value: Bytes("new"), Seq: 3})
+
+	sstable, err := Flush(mem, fss[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, keyCount(t, sstable))
+
+	value, err := sstable.GetValue(Bytes("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, Bytes("new"), value)
+
+	record, err := sstable.lookup(Bytes("a"), 2, true)
+	assert.NoError(t, err)
+	assert.Equal(t, Bytes("old"), record.GetValue())
 
-	index := genSparseIndex(mem)
-	assert.Equal(t, Bytes("1"), index[0].key)
-	assert.Equal(t, int64(0), index[0].offset)
+	_, err = sstable.lookup(Bytes("a"), 0, true)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
 
-	assert.Equal(t, Bytes("2"), index[1].key)
-	// 18 = 0(previous offset) + 8(size of len key) + 8(size of len value) + 1(len of "1") + 1(len of "2")
-	assert.Equal(t, int64(18), index[1].offset)
+	sstable, err = NewSSTable(fss[0])
+	assert.NoError(t, err)
 
-	assert.Equal(t, Bytes("3"), index[2].key)
-	// 36 = 18(previous offset) + 8(size of len key) + 8(size of len value) + 1(len of "1") + 1(len of "2")
-	assert.Equal(t, int64(36), index[2].offset)
+	iterator, err := sstable.Iterator()
+	assert.NoError(t, err)
+	seqs := make([]uint64, 0)
+	for iterator.HasNext() {
+		record, err := iterator.Next()
+		assert.NoError(t, err)
+		seqs = append(seqs, record.GetSeq())
+	}
+	assert.Equal(t, []uint64{3, 1, 2}, seqs)
 }
 
-//nolint:funlen
-func TestSparseIndex_GetOffset(t *testing.T) {
-	type args struct {
-		key Bytes
+// keyCount returns the number of keys stored in the sstable
+func keyCount(t *testing.T, sstable SStable) int {
+	keys, err := sstable.userKeys(Bytes{}, Bytes{0xff})
+	assert.NoError(t, err)
+	return len(keys)
+}
+
+func TestSStable_filter(t *testing.T) {
+	fss, closer := initTempFileSystems(t, 2)
+	defer closer()
+
+	mem := InitMemtable()
+	for i := 0; i < 100; i++ {
+		mem.Put(Bytes(fmt.Sprintf("key%03d", i)), Bytes("value"))
 	}
-	tests := []struct {
-		name    string
-		s       SparseIndex
-		args    args
-		want    int64
-		wantErr error
-	}{
-		{
-			name: "Get first key",
-			s: SparseIndex{
-				{Bytes("1"), 1},
-				{Bytes("2"), 2},
-				{Bytes("3"), 3},
-				{Bytes("4"), 4},
-			},
-			args:    args{Bytes("1")},
-			want:    1,
-			wantErr: nil,
-		},
-		{
-			name: "Get last key",
-			s: SparseIndex{
-				{Bytes("1"), 1},
-				{Bytes("2"), 2},
-				{Bytes("3"), 3},
-				{Bytes("4"), 4},
-			},
-			args:    args{Bytes("4")},
-			want:    4,
-			wantErr: nil,
-		},
-		{
-			name: "Get mid 1 key",
-			s: SparseIndex{
-				{Bytes("1"), 1},
-				{Bytes("2"), 2},
-				{Bytes("3"), 3},
-				{Bytes("4"), 4},
-			},
-			args:    args{Bytes("3")},
-			want:    3,
-			wantErr: nil,
-		},
-		{
-			name: "Get mid 2 key",
-			s: SparseIndex{
-				{Bytes("1"), 1},
-				{Bytes("2"), 2},
-				{Bytes("3"), 3},
-				{Bytes("4"), 4},
-			},
-			args:    args{Bytes("2")},
-			want:    2,
-			wantErr: nil,
-		},
-		{
-			name: "Get mid 3 key",
-			s: SparseIndex{
-				{Bytes("1"), 1},
-				{Bytes("2"), 2},
-				{Bytes("3"), 3},
-				{Bytes("4"), 4},
-				{Bytes("5"), 5},
-			},
-			args:    args{Bytes("3")},
-			want:    3,
-			wantErr: nil,
-		},
-		{
-			name: "Get on-exist key less than head",
-			s: SparseIndex{
-				{Bytes("1"), 1},
-				{Bytes("2"), 2},
-				{Bytes("3"), 3},
-				{Bytes("4"), 4},
-				{Bytes("5"), 5},
-			},
-			args:    args{Bytes("0")},
-			want:    0,
-			wantErr: ErrKeyNotFound,
-		},
-		{
-			name: "Get on-exist key greater than tail",
-			s: SparseIndex{
-				{Bytes("1"), 1},
-				{Bytes("2"), 2},
-				{Bytes("3"), 3},
-				{Bytes("4"), 4},
-				{Bytes("5"), 5},
-			},
-			args:    args{Bytes("6")},
-			want:    0,
-			wantErr: ErrKeyNotFound,
-		},
-		{
-			name: "Get on-exist key inside range",
-
-			s: SparseIndex{
-				{Bytes("1"), 1},
-				{Bytes("2"), 2},
-				{Bytes("4"), 4},
-				{Bytes("5"), 5},
-				{Bytes("6"), 6},
-			},
-			args:    args{Bytes("3")},
-			want:    0,
-			wantErr: ErrKeyNotFound,
-		},
+	_, err := writeSSTable(mem, fss[0], defaultTableOptions())
+	assert.NoError(t, err)
+
+	sstable, err := NewSSTable(fss[0])
+	assert.NoError(t, err)
+	assert.NotNil(t, sstable.filter)
+	for i := 0; i < 100; i++ {
+		assert.True(t, sstable.mayContain(Bytes(fmt.Sprintf("key%03d", i))))
+	}
+
+	// keys rejected by the filter are not read from the closed file
+	assert.NoError(t, fss[0].Close())
+	rejected := 0
+	for i := 100; i < 200; i++ {
+		key := Bytes(fmt.Sprintf("key%03d", i))
+		if sstable.mayContain(key) {
+			continue
+		}
+		rejected++
+		_, err := sstable.GetValue(key)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.s.GetOffset(tt.args.key)
-			assert.Equal(t, tt.wantErr, err)
-			assert.Equal(t, tt.want, got)
-		})
+	assert.Greater(t, rejected, 90)
+
+	opts := defaultTableOptions()
+	opts.bloomFalsePositive = 0
+	mem.Put(Bytes("key"), Bytes("value"))
+	_, err = writeSSTable(mem, fss[1], opts)
+	assert.NoError(t, err)
+	sstable, err = NewSSTable(fss[1])
+	assert.NoError(t, err)
+	assert.Nil(t, sstable.filter)
+}
+
+// corruptValue flips the first byte of value stored in the block of handle
+func corruptValue(t *testing.T, path string, handle blockHandle, value Bytes) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, file.Close()) }()
+
+	data := make([]byte, handle.size)
+	_, err = file.ReadAt(data, int64(handle.offset))
+	assert.NoError(t, err)
+	at := bytes.Index(data, value)
+	assert.GreaterOrEqual(t, at, 0)
+
+	_, err = file.WriteAt([]byte{data[at] ^ 0xff}, int64(handle.offset)+int64(at))
+	assert.NoError(t, err)
+}
+
+func TestSStable_checksums(t *testing.T) {
+	fss, closer := initTempFileSystems(t, 1)
+	defer closer()
+
+	mem := InitMemtable()
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		mem.Put(Bytes(key), Bytes("value-"+key))
 	}
+	opts := defaultTableOptions()
+	opts.blockSize = 256
+	sstable, err := writeSSTable(mem, fss[0], opts)
+	assert.NoError(t, err)
+	assert.NoError(t, sstable.Verify())
+
+	handle := sstable.blocks[1].handle
+	key := sstable.blocks[1].lastKey.UserKey
+	corruptValue(t, fss[0].Path(), handle, Bytes("value-"+string(key)))
+
+	_, err = sstable.GetValue(key)
+	var corruption *CorruptionError
+	assert.ErrorAs(t, err, &corruption)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Equal(t, fss[0].Path(), corruption.File)
+	assert.Equal(t, handle.offset, corruption.Offset)
+
+	// keys of the other blocks are still readable
+	value, err := sstable.GetValue(Bytes("key000"))
+	assert.NoError(t, err)
+	assert.Equal(t, Bytes("value-key000"), value)
+
+	// skipping the checksums reads the corrupted value
+	record, err := sstable.lookup(key, MaxSequence, false)
+	assert.NoError(t, err)
+	assert.NotEqual(t, Bytes("value-"+string(key)), record.GetValue())
+
+	err = sstable.Verify()
+	assert.ErrorAs(t, err, &corruption)
+	assert.Equal(t, handle.offset, corruption.Offset)
 }
@@ -2,83 +2,445 @@ package rindb
 
 import (
 	"bytes"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"log"
+	"os"
+	"path"
 
 	"github.com/pkg/errors"
 )
 
-type WAL struct{ *FileSystem }
+// Every entry of the WAL, a record or a batch, is framed by its length
+// and the CRC32C of its payload, both as 4 bytes numbers:
+//
+//	[length][checksum][payload]
+//
+// walBatchHeader starts the payload of a batch entry, it is followed by
+// the number of records of the batch then the records. It never collides
+// with a record type.
+const (
+	walBatchHeader byte = 0xff
+
+	walFrameHeaderSize = 8
+)
+
+var (
+	crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+	ErrCorruptedWAL = errors.New("corrupted WAL")
+)
+
+// WALRecoveryMode tells how WAL.Load handles corrupted entries
+type WALRecoveryMode int
+
+const (
+	// WALRecoveryTolerateCorruptedTail drops a torn or corrupted last entry,
+	// left by a crash while it was written. Other corruptions fail the load.
+	WALRecoveryTolerateCorruptedTail WALRecoveryMode = iota
+	// WALRecoveryAbsoluteConsistency fails the load on any corruption.
+	WALRecoveryAbsoluteConsistency
+	// WALRecoverySkipAnyCorrupted drops every corrupted entry and keeps
+	// loading the following ones.
+	WALRecoverySkipAnyCorrupted
+	// WALRecoveryPointInTime stops at the first corruption, the entries
+	// following it are dropped.
+	WALRecoveryPointInTime
+)
+
+// WALCorruption is a part of a WAL dropped by the recovery
+type WALCorruption struct {
+	File   string
+	Offset int64
+	Size   int64
+	Reason string
+}
+
+func (c WALCorruption) String() string {
+	return fmt.Sprintf("%s: %d bytes at offset %d: %s", c.File, c.Size, c.Offset, c.Reason)
+}
+
+// WALRecoveryReport lists what the recovery of the WALs dropped
+type WALRecoveryReport struct {
+	Corruptions []WALCorruption
+}
+
+// DroppedBytes returns the number of bytes dropped by the recovery
+func (r WALRecoveryReport) DroppedBytes() int64 {
+	dropped := int64(0)
+	for _, corruption := range r.Corruptions {
+		dropped += corruption.Size
+	}
+	return dropped
+}
+
+// walBufferSize is the number of bytes of pending entries from which
+// they are written without waiting for Flush
+const walBufferSize = 1 << 20
+
+type WAL struct {
+	*FileSystem
+
+	// pending holds the entries appended without sync while manualFlush
+	// is set, see Options.ManualWALFlush. Flush writes them to the file.
+	pending     *bytes.Buffer
+	manualFlush bool
+
+	// recoveryMode is used by Load, see Options.WALRecoveryMode
+	recoveryMode WALRecoveryMode
+
+	// log receives the entries dropped by Load
+	log dbLogger
+
+	// err fails every append once the file can't be trusted anymore:
+	// a torn entry could not be cut off or a sync failed
+	err error
+}
 
 func NewWAL(fs *FileSystem) WAL {
-	return WAL{fs}
+	return WAL{FileSystem: fs, pending: bytes.NewBuffer(nil), log: dbLogger{log.Default()}}
+}
+
+// Flush writes the pending entries to the file, without fsync
+func (w *WAL) Flush() error {
+	if w.pending.Len() == 0 {
+		return nil
+	}
+	if err := w.appendBuffer(w.pending, false); err != nil {
+		return err
+	}
+	w.pending.Reset()
+	return nil
+}
+
+// Close writes the pending entries then closes the file
+func (w *WAL) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.FileSystem.Close()
 }
 
 func (w *WAL) Load() (Memtable, error) {
-	_, err := w.file.Seek(0, io.SeekStart)
+	mem, _, err := w.Recover()
+	return mem, err
+}
+
+// Recover loads the entries of the WAL following its recovery mode and
+// reports the dropped ones. The WAL is repaired without them, so new
+// entries are not appended after a corruption.
+func (w *WAL) Recover() (Memtable, WALRecoveryReport, error) {
+	report := WALRecoveryReport{}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return Memtable{}, report, errors.Wrap(err, "failed to seek to start of file")
+	}
+	data, err := io.ReadAll(w.file)
 	if err != nil {
-		return Memtable{}, errors.Wrap(err, "failed to seek to start of file: %w")
+		return Memtable{}, report, errors.Wrap(err, "failed to read WAL")
 	}
+
 	mem := InitMemtable()
-	ce := 0
-	for {
-		record, err := ReadRecord(w.file)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+	kept := bytes.NewBuffer(nil)
+	for offset := 0; offset < len(data); {
+		records, size, reason := readFrame(data[offset:])
+		if reason == "" {
+			for _, record := range records {
+				mem.PutRecord(record)
 			}
+			kept.Write(data[offset : offset+size])
+			offset += size
+			continue
+		}
+
+		// the length isn't covered by the checksum, so the corruption spans
+		// up to the next valid frame, or the rest of the WAL when there's none
+		next := resyncFrame(data, offset, size)
+		atTail := next == len(data)
+		size = next - offset
+		if w.recoveryMode == WALRecoveryPointInTime {
+			size = len(data) - offset
+		}
+		corruption := WALCorruption{File: w.Path(), Offset: int64(offset), Size: int64(size), Reason: reason}
 
-			return Memtable{}, err
+		switch {
+		case w.recoveryMode == WALRecoveryAbsoluteConsistency,
+			w.recoveryMode == WALRecoveryTolerateCorruptedTail && !atTail:
+			return Memtable{}, report, errors.Wrap(ErrCorruptedWAL, corruption.String())
 		}
+		w.log.WARN("Dropping corrupted WAL entry %s", corruption)
+		report.Corruptions = append(report.Corruptions, corruption)
+		offset += size
+	}
 
-		ce += CalOnDiskSize(record)
-		mem.Put(record.GetKey(), record.GetValue())
+	if len(report.Corruptions) > 0 {
+		if err := w.repair(report.Corruptions[0], kept.Bytes()); err != nil {
+			return Memtable{}, report, errors.Wrap(err, "failed to repair recovered WAL")
+		}
 	}
-	return mem, nil
+	return mem, report, nil
 }
 
-func (w *WAL) Append(record Record) error {
-	_, err := w.file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return errors.Wrap(err, "failed to seek to end of file: %w")
+// readFramePayload returns the payload of the frame written by writeFrame
+// starting data and the frame size, or the reason why it is corrupted.
+// The size is 0 when the frame header is unreadable.
+func readFramePayload(data []byte) ([]byte, int, string) {
+	if len(data) < walFrameHeaderSize {
+		return nil, 0, "incomplete frame header"
 	}
 
-	err = WriteRecord(w, record)
-	if err != nil {
-		return errors.Wrap(err, "failed to write to file: %w")
+	length := int(byteOrder.Uint32(data))
+	checksum := byteOrder.Uint32(data[4:])
+	if length > len(data)-walFrameHeaderSize {
+		return nil, 0, "incomplete entry"
 	}
 
-	err = w.Sync()
+	size := walFrameHeaderSize + length
+	payload := data[walFrameHeaderSize:size]
+	if crc32.Checksum(payload, crc32c) != checksum {
+		return nil, size, "checksum mismatch"
+	}
+	return payload, size, ""
+}
+
+// readFrame decodes the entry starting data, it returns its records and
+// its size, or the reason why it is corrupted. The size is 0 when the
+// frame header is unreadable.
+func readFrame(data []byte) ([]Record, int, string) {
+	payload, size, reason := readFramePayload(data)
+	if reason != "" {
+		return nil, size, reason
+	}
+
+	reader := bytes.NewReader(payload)
+	var records []Record
+	if len(payload) > 0 && payload[0] == walBatchHeader {
+		batch, err := readBatch(reader)
+		if err != nil {
+			return nil, size, err.Error()
+		}
+		records = batch
+	} else {
+		record, err := ReadRecord(reader)
+		if err != nil {
+			return nil, size, err.Error()
+		}
+		records = []Record{record}
+	}
+	if reader.Len() > 0 {
+		return nil, size, "trailing bytes in entry"
+	}
+	return records, size, ""
+}
+
+// resyncFrame returns the offset of the first valid frame after the
+// corrupted one at offset, whose header claims size bytes, or len(data)
+func resyncFrame(data []byte, offset, size int) int {
+	if size > 0 && offset+size < len(data) {
+		if _, _, reason := readFrame(data[offset+size:]); reason == "" {
+			return offset + size
+		}
+	}
+	for next := offset + 1; next < len(data); next++ {
+		if _, _, reason := readFrame(data[next:]); reason == "" {
+			return next
+		}
+	}
+	return len(data)
+}
+
+// readBatch reads a batch entry written by AppendBatch
+func readBatch(reader io.Reader) ([]Record, error) {
+	header := [recordTypeSize]byte{}
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, errors.Wrap(err, "failed to read batch header")
+	}
+
+	count, err := ReadNumber(reader)
 	if err != nil {
-		return errors.Wrap(err, "failed to sync file: %w")
+		return nil, errors.Wrap(err, "failed to read batch count")
 	}
 
-	return nil
+	records := make([]Record, 0)
+	for uint64(len(records)) < count {
+		record, err := ReadRecord(reader)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read record %d of batch", len(records))
+		}
+		records = append(records, record)
+	}
+	return records, nil
 }
 
-func (w *WAL) AppendMany(records []Record) error {
-	_, err := w.file.Seek(0, io.SeekEnd)
+// repair drops the corrupted entries of the WAL, kept are its valid
+// entries. Corruptions at the tail only are cut off. Otherwise kept is
+// written to a temporary file which replaces the WAL once synced, so a
+// crash meanwhile never loses the valid entries.
+func (w *WAL) repair(first WALCorruption, kept []byte) error {
+	if int64(len(kept)) == first.Offset {
+		if err := w.file.Truncate(first.Offset); err != nil {
+			return err
+		}
+		return w.Sync()
+	}
+
+	walPath := w.Path()
+	tmpPath := walPath + ".tmp"
+	if err := writeSynced(tmpPath, kept); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, walPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	// the rename is durable once the directory is synced
+	if err := syncDir(path.Dir(walPath)); err != nil {
+		return err
+	}
+
+	if err := w.FileSystem.Close(); err != nil {
+		return err
+	}
+	return w.Open()
+}
+
+// writeSynced writes data to a new file at filePath and fsyncs it
+func writeSynced(filePath string, data []byte) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileSystemPermission)
 	if err != nil {
-		return errors.Wrap(err, "failed to seek to end of file: %w")
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
 	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
 
+// writeFrame appends the frame of payload to txBuf
+func writeFrame(txBuf *bytes.Buffer, payload []byte) {
+	var header [walFrameHeaderSize]byte
+	byteOrder.PutUint32(header[:], uint32(len(payload)))
+	byteOrder.PutUint32(header[4:], crc32.Checksum(payload, crc32c))
+	txBuf.Write(header[:])
+	txBuf.Write(payload)
+}
+
+func (w *WAL) Append(record Record) error {
+	return w.AppendMany([]Record{record})
+}
+
+// AppendMany appends every record as its own entry
+func (w *WAL) AppendMany(records []Record) error {
 	// write to string buffer and write back to file
 	// to make sure that all data must be persistent
 	txBuf := bytes.NewBufferString("")
 	for _, record := range records {
-		err := WriteRecord(txBuf, record)
+		if err := writeRecordFrame(txBuf, record); err != nil {
+			return err
+		}
+	}
+	return w.appendBuffer(txBuf, true)
+}
+
+// AppendBatch appends the records as one batch entry, Load replays all of
+// them or none of them
+func (w *WAL) AppendBatch(records []Record) error {
+	txBuf := bytes.NewBufferString("")
+	if err := writeBatchFrame(txBuf, records); err != nil {
+		return err
+	}
+	return w.appendBuffer(txBuf, true)
+}
+
+// AppendGroup appends the entries of several writes with a single write,
+// then fsyncs the file with sync. An entry of several records is appended
+// as a batch entry. Entries appended without sync are kept pending with
+// manualFlush.
+func (w *WAL) AppendGroup(entries [][]Record, sync bool) error {
+	txBuf := bytes.NewBufferString("")
+	for _, records := range entries {
+		var err error
+		if len(records) == 1 {
+			err = writeRecordFrame(txBuf, records[0])
+		} else {
+			err = writeBatchFrame(txBuf, records)
+		}
 		if err != nil {
-			return errors.Wrap(err, "failed to write to buffer: %w")
+			return err
+		}
+	}
+
+	if w.manualFlush && !sync {
+		w.pending.Write(txBuf.Bytes())
+		if w.pending.Len() < walBufferSize {
+			return nil
+		}
+		return w.Flush()
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.appendBuffer(txBuf, sync)
+}
+
+func writeRecordFrame(txBuf *bytes.Buffer, record Record) error {
+	payload := bytes.NewBufferString("")
+	if err := WriteRecord(payload, record); err != nil {
+		return errors.Wrap(err, "failed to write to buffer: %w")
+	}
+	writeFrame(txBuf, payload.Bytes())
+	return nil
+}
+
+func writeBatchFrame(txBuf *bytes.Buffer, records []Record) error {
+	payload := bytes.NewBuffer([]byte{walBatchHeader})
+	if err := WriteNumber(payload, uint64(len(records))); err != nil {
+		return errors.Wrap(err, "failed to write batch count")
+	}
+	for _, record := range records {
+		if err := WriteRecord(payload, record); err != nil {
+			return errors.Wrap(err, "failed to write to buffer")
 		}
 	}
+	writeFrame(txBuf, payload.Bytes())
+	return nil
+}
+
+// appendBuffer writes txBuf at the end of the file, then fsyncs it with sync
+func (w *WAL) appendBuffer(txBuf *bytes.Buffer, sync bool) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	end, err := w.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrap(err, "failed to seek to end of file: %w")
+	}
 
 	_, err = w.Write(txBuf.Bytes())
 	if err != nil {
+		// entries appended after a torn one would make it a corruption
+		// in the middle of the WAL, so it's cut off
+		if truncErr := w.file.Truncate(end); truncErr != nil {
+			w.err = errors.Wrapf(err, "WAL %s is unusable after a failed write", w.Path())
+		}
 		return errors.Wrap(err, "failed to write to file: %w")
 	}
 
+	if !sync {
+		return nil
+	}
 	err = w.Sync()
 	if err != nil {
-		return errors.Wrap(err, "failed to sync file: %w")
+		// the written entries may or may not be durable
+		w.err = errors.Wrapf(err, "WAL %s is unusable after a failed sync", w.Path())
+		return w.err
 	}
 
 	return nil
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,13 +16,25 @@ func validateWALFormat(t *testing.T, file io.ReadSeeker) {
 	assert.NoError(t, err)
 
 	for {
-		keyLenBytes := [mdByteSize]byte{}
-		_, err := file.Read(keyLenBytes[:])
+		frameBytes := [walFrameHeaderSize]byte{}
+		_, err := file.Read(frameBytes[:])
 		if errors.Is(err, io.EOF) {
 			break
 		}
 		assert.NoError(t, err)
 
+		typeBytes := [recordTypeSize]byte{}
+		_, err = file.Read(typeBytes[:])
+		assert.NoError(t, err)
+
+		seqBytes := [mdByteSize]byte{}
+		_, err = file.Read(seqBytes[:])
+		assert.NoError(t, err)
+
+		keyLenBytes := [mdByteSize]byte{}
+		_, err = file.Read(keyLenBytes[:])
+		assert.NoError(t, err)
+
 		valueLenBytes := [mdByteSize]byte{}
 		_, err = file.Read(valueLenBytes[:])
 		assert.NoError(t, err)
@@ -68,6 +82,21 @@ func Test_wal(t *testing.T) {
 		assert.NoError(t, err)
 	})
 
+	t.Run("Load tombstones", func(t *testing.T) {
+		fss, closer := initTempFileSystems(t, 1)
+		defer closer()
+
+		w := NewWAL(fss[0])
+		assert.NoError(t, w.Append(RecordImpl{Key: Bytes("key"), Value: Bytes("value")}))
+		assert.NoError(t, w.Append(RecordImpl{Key: Bytes("key"), Type: RecordTypeDelete}))
+
+		mem, err := w.Load()
+		assert.NoError(t, err)
+
+		_, err = mem.Get(Bytes("key"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	})
+
 	t.Run("Write and load WAL", func(t *testing.T) {
 		fss, closer := initTempFileSystems(t, 1)
 		defer closer()
@@ -75,7 +104,7 @@ func Test_wal(t *testing.T) {
 		fs := fss[0]
 		w := NewWAL(fs)
 
-		err := w.Append(RecordImpl{Bytes("single_key"), Bytes("single_value")})
+		err := w.Append(RecordImpl{Key: Bytes("single_key"), Value: Bytes("single_value")})
 		assert.NoError(t, err)
 
 		recordsSize := 1_000
@@ -83,7 +112,7 @@ func Test_wal(t *testing.T) {
 		for i := 0; i < recordsSize; i++ {
 			key := Bytes(fmt.Sprintf("key.%d", i))
 			value := Bytes(fmt.Sprintf("value.%d", i))
-			records = append(records, RecordImpl{key, value})
+			records = append(records, RecordImpl{Key: key, Value: value})
 		}
 
 		err = w.AppendMany(records)
@@ -107,4 +136,260 @@ func Test_wal(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, Bytes("single_value"), got)
 	})
+
+	t.Run("Load batches all or nothing", func(t *testing.T) {
+		fss, closer := initTempFileSystems(t, 1)
+		defer closer()
+
+		w := NewWAL(fss[0])
+		assert.NoError(t, w.Append(RecordImpl{Key: Bytes("single"), Value: Bytes("single"), Seq: 1}))
+		assert.NoError(t, w.AppendBatch([]Record{
+			RecordImpl{Key: Bytes("a"), Value: Bytes("a"), Seq: 2},
+			RecordImpl{Key: Bytes("b"), Value: Bytes("b"), Seq: 3},
+		}))
+		assert.NoError(t, w.AppendBatch([]Record{
+			RecordImpl{Key: Bytes("c"), Value: Bytes("c"), Seq: 4},
+			RecordImpl{Key: Bytes("d"), Value: Bytes("d"), Seq: 5},
+		}))
+
+		mem, err := w.Load()
+		assert.NoError(t, err)
+		assert.Equal(t, uint(5), mem.data.Len())
+		assert.Equal(t, uint64(5), mem.LastSeq())
+
+		// tear the last record of the last batch
+		info, err := fss[0].file.Stat()
+		assert.NoError(t, err)
+		assert.NoError(t, fss[0].file.Truncate(info.Size()-1))
+
+		mem, err = w.Load()
+		assert.NoError(t, err)
+		assert.Equal(t, uint(3), mem.data.Len())
+		_, err = mem.Get(Bytes("c"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		value, err := mem.Get(Bytes("b"))
+		assert.NoError(t, err)
+		assert.Equal(t, Bytes("b"), value)
+	})
+}
+
+func TestWAL_AppendGroup(t *testing.T) {
+	fss, closer := initTempFileSystems(t, 1)
+	defer closer()
+
+	w := NewWAL(fss[0])
+	assert.NoError(t, w.AppendGroup([][]Record{
+		{RecordImpl{Key: Bytes("a"), Value: Bytes("a"), Seq: 1}},
+		{
+			RecordImpl{Key: Bytes("b"), Value: Bytes("b"), Seq: 2},
+			RecordImpl{Key: Bytes("c"), Value: Bytes("c"), Seq: 3},
+		},
+		{RecordImpl{Key: Bytes("a"), Type: RecordTypeDelete, Seq: 4}},
+	}, true))
+
+	mem, err := w.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(4), mem.data.Len())
+	assert.Equal(t, uint64(4), mem.LastSeq())
+	_, err = mem.Get(Bytes("a"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// the batch of the group is still replayed as a whole
+	info, err := fss[0].file.Stat()
+	assert.NoError(t, err)
+	size := info.Size()
+	assert.NoError(t, w.AppendGroup([][]Record{{
+		RecordImpl{Key: Bytes("d"), Value: Bytes("d"), Seq: 5},
+		RecordImpl{Key: Bytes("e"), Value: Bytes("e"), Seq: 6},
+	}}, true))
+	assert.NoError(t, fss[0].file.Truncate(size+walFrameHeaderSize+3))
+	mem, err = w.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(4), mem.data.Len())
+}
+
+func TestWAL_manualFlush(t *testing.T) {
+	fss, closer := initTempFileSystems(t, 1)
+	defer closer()
+
+	w := NewWAL(fss[0])
+	w.manualFlush = true
+	fileSize := func() int64 {
+		info, err := os.Stat(fss[0].Path())
+		assert.NoError(t, err)
+		return info.Size()
+	}
+	entry := func(seq uint64) [][]Record {
+		return [][]Record{{RecordImpl{Key: Bytes(fmt.Sprint(seq)), Seq: seq}}}
+	}
+
+	assert.NoError(t, w.AppendGroup(entry(1), false))
+	assert.Zero(t, fileSize())
+	assert.NoError(t, w.Flush())
+	flushed := fileSize()
+	assert.NotZero(t, flushed)
+
+	// a synced entry writes the pending ones before it
+	assert.NoError(t, w.AppendGroup(entry(2), false))
+	assert.Equal(t, flushed, fileSize())
+	assert.NoError(t, w.AppendGroup(entry(3), true))
+	assert.Equal(t, 3*flushed, fileSize())
+
+	assert.NoError(t, w.AppendGroup(entry(4), false))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, 4*flushed, fileSize())
+
+	assert.NoError(t, fss[0].Open())
+	mem, err := w.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), mem.LastSeq())
+}
+
+// corruptedWAL writes 3 entries, flips a byte of the entry at corrupted
+// then appends tail to the WAL
+func corruptedWAL(t *testing.T, fs *FileSystem, corrupted int, tail []byte) WAL {
+	w := NewWAL(fs)
+	offsets := make([]int64, 0, 3)
+	for i := 0; i < 3; i++ {
+		offset, err := fs.file.Seek(0, io.SeekEnd)
+		assert.NoError(t, err)
+		offsets = append(offsets, offset)
+		assert.NoError(t, w.Append(RecordImpl{Key: Bytes(fmt.Sprintf("key%d", i)), Seq: uint64(i + 1)}))
+	}
+
+	if corrupted >= 0 {
+		at := offsets[corrupted] + walFrameHeaderSize + 1
+		_, err := fs.file.WriteAt([]byte{0xaa}, at)
+		assert.NoError(t, err)
+	}
+	_, err := fs.file.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	_, err = fs.Write(tail)
+	assert.NoError(t, err)
+	return w
+}
+
+//nolint:funlen
+func Test_walRecovery(t *testing.T) {
+	// a frame header announcing more bytes than written
+	tornTail := []byte{100, 0, 0, 0, 1, 2, 3, 4, 5}
+
+	tests := []struct {
+		name      string
+		mode      WALRecoveryMode
+		corrupted int
+		tail      []byte
+		wantErr   bool
+		wantLen   uint
+		dropped   int
+	}{
+		{"tolerate torn tail", WALRecoveryTolerateCorruptedTail, -1, tornTail, false, 3, 1},
+		{"tolerate corrupted last entry", WALRecoveryTolerateCorruptedTail, 2, nil, false, 2, 1},
+		{"tolerate fails in the middle", WALRecoveryTolerateCorruptedTail, 1, nil, true, 0, 0},
+		{"absolute fails on torn tail", WALRecoveryAbsoluteConsistency, -1, tornTail, true, 0, 0},
+		{"absolute loads a clean WAL", WALRecoveryAbsoluteConsistency, -1, nil, false, 3, 0},
+		{"skip drops the corrupted entry", WALRecoverySkipAnyCorrupted, 1, tornTail, false, 2, 2},
+		{"point in time stops at the corruption", WALRecoveryPointInTime, 1, nil, false, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fss, closer := initTempFileSystems(t, 1)
+			defer closer()
+
+			w := corruptedWAL(t, fss[0], tt.corrupted, tt.tail)
+			w.recoveryMode = tt.mode
+			mem, report, err := w.Recover()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrCorruptedWAL)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLen, mem.data.Len())
+			assert.Len(t, report.Corruptions, tt.dropped)
+
+			// the WAL is repaired without the dropped entries
+			assert.NoFileExists(t, w.Path()+".tmp")
+			w.recoveryMode = WALRecoveryAbsoluteConsistency
+			mem, report, err = w.Recover()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLen, mem.data.Len())
+			assert.Empty(t, report.Corruptions)
+		})
+	}
+}
+
+func TestWAL_corruptedLength(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		mode    WALRecoveryMode
+		wantErr bool
+	}{
+		{"tolerate fails in the middle", WALRecoveryTolerateCorruptedTail, true},
+		{"skip keeps the following entries", WALRecoverySkipAnyCorrupted, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fss, closer := initTempFileSystems(t, 1)
+			defer closer()
+
+			// the length of the second entry points past the end of the WAL
+			w := corruptedWAL(t, fss[0], -1, nil)
+			size, err := fss[0].file.Seek(0, io.SeekEnd)
+			assert.NoError(t, err)
+			_, err = fss[0].file.WriteAt([]byte{0xaa}, size/3+1)
+			assert.NoError(t, err)
+
+			w.recoveryMode = tt.mode
+			mem, report, err := w.Recover()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrCorruptedWAL)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, uint(2), mem.data.Len())
+			assert.Len(t, report.Corruptions, 1)
+			assert.Equal(t, size/3, report.Corruptions[0].Size)
+		})
+	}
+}
+
+func TestWAL_repair(t *testing.T) {
+	fss, closer := initTempFileSystems(t, 1)
+	defer closer()
+
+	// the temporary file can't be written
+	w := corruptedWAL(t, fss[0], 1, nil)
+	w.recoveryMode = WALRecoverySkipAnyCorrupted
+	assert.NoError(t, os.Mkdir(w.Path()+".tmp", 0o755))
+	_, _, err := w.Recover()
+	assert.Error(t, err)
+	assert.NoDirExists(t, w.Path()+".tmp")
+
+	mem, report, err := w.Recover()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), mem.data.Len())
+	assert.Len(t, report.Corruptions, 1)
+	assert.NoFileExists(t, w.Path()+".tmp")
+}
+
+func TestWAL_failedAppend(t *testing.T) {
+	filePath := path.Join(t.TempDir(), walSegmentName(1))
+	fs, err := OpenFS(filePath)
+	assert.NoError(t, err)
+	w := NewWAL(fs)
+	assert.NoError(t, w.Append(RecordImpl{Key: Bytes("a"), Seq: 1}))
+	assert.NoError(t, w.Close())
+	info, err := os.Stat(filePath)
+	assert.NoError(t, err)
+
+	// writes to a read only file fail and it can't be truncated
+	file, err := os.Open(filePath)
+	assert.NoError(t, err)
+	w = NewWAL(NewFS(file))
+	defer func() { _ = file.Close() }()
+	assert.Error(t, w.Append(RecordImpl{Key: Bytes("b"), Seq: 2}))
+	assert.ErrorContains(t, w.Append(RecordImpl{Key: Bytes("c"), Seq: 3}), "unusable")
+
+	stat, err := os.Stat(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, info.Size(), stat.Size())
 }
@@ -0,0 +1,130 @@
+package rindb
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+The WAL is split into numbered segments, a new one is started on every
+memtable switch. A segment is recorded in the MANIFEST before it's
+created and forgotten once its memtable is flushed, then it's removed or
+moved to Options.WALArchiveDir. On open, the live segments are replayed
+in number order: the older ones are flushed again and the newest one
+keeps backing the memtable.
+*/
+
+// walSegmentPrefix is followed by the zero padded number of the
+// segment, so the segments sort by name in creation order
+const walSegmentPrefix = walName + "-"
+
+func walSegmentName(number uint64) string {
+	return fmt.Sprintf("%s%06d", walSegmentPrefix, number)
+}
+
+// parseWALSegment returns the number of the segment named name,
+// false when it's not a WAL segment
+func parseWALSegment(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, walSegmentPrefix) {
+		return 0, false
+	}
+	number, err := strconv.ParseUint(strings.TrimPrefix(name, walSegmentPrefix), 10, 64)
+	if err != nil || number == 0 {
+		return 0, false
+	}
+	return number, true
+}
+
+// listWALSegments returns the numbers of the segments stored in dir, ascending
+func listWALSegments(dir string) ([]uint64, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]uint64, 0)
+	for _, dirEntry := range dirEntries {
+		if number, ok := parseWALSegment(dirEntry.Name()); ok {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+// retireWAL removes a WAL whose memtable is flushed, or moves it to
+// opts.WALArchiveDir. A missing WAL is already retired.
+func retireWAL(walPath string, opts *Options) error {
+	if opts.WALArchiveDir == "" {
+		err := os.Remove(walPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if err := os.MkdirAll(opts.WALArchiveDir, 0o755); err != nil {
+		return errors.Wrap(err, "failed to create WAL archive")
+	}
+	archivedPath := path.Join(opts.WALArchiveDir, path.Base(walPath))
+	err := os.Rename(walPath, archivedPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to archive WAL %s", walPath)
+	}
+
+	// the TTL runs from the archiving
+	now := time.Now()
+	if err := os.Chtimes(archivedPath, now, now); err != nil {
+		return err
+	}
+	return purgeWALArchive(opts)
+}
+
+// purgeWALArchive removes the archived WALs older than opts.WALArchiveTTL
+func purgeWALArchive(opts *Options) error {
+	if opts.WALArchiveDir == "" || opts.WALArchiveTTL <= 0 {
+		return nil
+	}
+
+	dirEntries, err := os.ReadDir(opts.WALArchiveDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	expiry := time.Now().Add(-opts.WALArchiveTTL)
+	for _, dirEntry := range dirEntries {
+		if !strings.HasPrefix(dirEntry.Name(), walName) {
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(expiry) {
+			continue
+		}
+
+		err = os.Remove(path.Join(opts.WALArchiveDir, dirEntry.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
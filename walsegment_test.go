@@ -0,0 +1,63 @@
+package rindb
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseWALSegment(t *testing.T) {
+	number, ok := parseWALSegment(walSegmentName(42))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(42), number)
+
+	for _, name := range []string{walName, frozenWALPrefix + "01", walSegmentPrefix, walSegmentPrefix + "x", walSegmentName(0)} {
+		_, ok := parseWALSegment(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func Test_retireWAL(t *testing.T) {
+	createWAL := func(t *testing.T, walPath string) {
+		fs, err := OpenFS(walPath)
+		assert.NoError(t, err)
+		assert.NoError(t, fs.Close())
+	}
+
+	t.Run("removed without archive", func(t *testing.T) {
+		walPath := path.Join(t.TempDir(), walSegmentName(1))
+		createWAL(t, walPath)
+		assert.NoError(t, retireWAL(walPath, DefaultOptions()))
+		assert.NoFileExists(t, walPath)
+
+		// retiring twice is fine
+		assert.NoError(t, retireWAL(walPath, DefaultOptions()))
+	})
+
+	t.Run("archived until the TTL expires", func(t *testing.T) {
+		dir := t.TempDir()
+		opts := &Options{WALArchiveDir: path.Join(dir, "archive"), WALArchiveTTL: time.Hour}
+
+		expiredPath := path.Join(opts.WALArchiveDir, walSegmentName(1))
+		assert.NoError(t, os.MkdirAll(opts.WALArchiveDir, 0o755))
+		createWAL(t, expiredPath)
+		old := time.Now().Add(-2 * time.Hour)
+		assert.NoError(t, os.Chtimes(expiredPath, old, old))
+
+		walPath := path.Join(dir, walSegmentName(2))
+		createWAL(t, walPath)
+		assert.NoError(t, retireWAL(walPath, opts))
+		assert.NoFileExists(t, walPath)
+		assert.NoFileExists(t, expiredPath)
+		assert.FileExists(t, path.Join(opts.WALArchiveDir, walSegmentName(2)))
+
+		// zero TTL keeps the archived WALs
+		opts.WALArchiveTTL = 0
+		assert.NoError(t, os.Chtimes(path.Join(opts.WALArchiveDir, walSegmentName(2)), old, old))
+		assert.NoError(t, purgeWALArchive(opts))
+		assert.FileExists(t, path.Join(opts.WALArchiveDir, walSegmentName(2)))
+	})
+}